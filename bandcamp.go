@@ -1,31 +1,64 @@
 package StreamTool
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io/ioutil"
 	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
 	"golang.org/x/net/html"
 )
 
-type bandcampFile struct {
-	URL string `json:"mp3-128"`
-}
-
 type bandcampTrack struct {
 	Title      string
 	Title_Link string
+	Artist     string
+	Track_Num  int
 	Duration   stringWrapper
-	File       bandcampFile
+	File       map[string]string
+}
+
+type bandcampCurrent struct {
+	Title        string
+	About        string
+	Release_Date string
 }
 
 type bandcampJSON struct {
-	TrackInfo []bandcampTrack
+	Artist             string
+	Item_Type          string
+	Album_Release_Date string
+	Current            bandcampCurrent
+	TrackInfo          []bandcampTrack
+}
+
+// build a Format from a trackinfo file entry
+func bandcampFormat(encoding string, streamURL string) Format {
+	format := Format{
+		URL:      streamURL,
+		HasAudio: true,
+		Protocol: ProtocolHTTPS,
+	}
+
+	parts := strings.SplitN(encoding, "-", 2)
+	format.Container = parts[0]
+	format.Codec = parts[0]
+	if parts[0] == "mp3" {
+		format.MimeType = "audio/mpeg"
+	}
+
+	// "v0" style encodings are variable bitrate
+	if len(parts) == 2 {
+		if kbps, err := strconv.Atoi(parts[1]); err == nil {
+			format.Bitrate = kbps * 1000
+		}
+	}
+
+	return format
 }
 
 func findBandcampJSON(node *html.Node) bool {
@@ -42,33 +75,34 @@ func findBandcampJSON(node *html.Node) bool {
 	return false
 }
 
-func parseBandcamp(url string, urlRx *regexp.Regexp) ([]StreamData, error) {
+func (c *Client) parseBandcamp(ctx context.Context, url string, urlRx *regexp.Regexp) (*Result, error) {
 	var streamData []StreamData
+	result := &Result{Kind: KindTrack}
 
 	// fetch url
-	resp, err := netClient.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't fetch url: %w", err)
+		return result, fetchError("bandcamp", StageFetch, "couldn't fetch url", err)
 	}
 	defer resp.Body.Close()
 
 	// read pageBody as string (needed later)
 	bodyReader, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't read body: %w", err)
+		return result, fetchError("bandcamp", StageFetch, "couldn't read body", err)
 	}
 	pageBody := string(bodyReader)
 
 	// parse html
 	doc, err := html.Parse(strings.NewReader(pageBody))
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't parse html: %w", err)
+		return result, newExtractError("bandcamp", StageDecode, ErrLayoutChanged, "couldn't parse html: %w", err)
 	}
 
 	// find script node containing json attr
 	node := findNode(doc, findBandcampJSON)
 	if node == nil {
-		return streamData, errors.New("couldn't find json")
+		return result, newExtractError("bandcamp", StageLocateJSON, ErrLayoutChanged, "couldn't find json")
 	}
 
 	// extract data
@@ -80,14 +114,14 @@ func parseBandcamp(url string, urlRx *regexp.Regexp) ([]StreamData, error) {
 	}
 
 	if nodeData == "" {
-		return streamData, errors.New("couldn't extract node data")
+		return result, newExtractError("bandcamp", StageLocateJSON, ErrLayoutChanged, "couldn't extract node data")
 	}
 
 	// parse json
 	var jsonData bandcampJSON
 	err = json.Unmarshal([]byte(nodeData), &jsonData)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't parse json: %w", err)
+		return result, newExtractError("bandcamp", StageDecode, ErrLayoutChanged, "couldn't parse json: %w", err)
 	}
 
 	// get album art
@@ -98,6 +132,29 @@ func parseBandcamp(url string, urlRx *regexp.Regexp) ([]StreamData, error) {
 		albumArtURL = rxAlbumArt[0][1]
 	}
 
+	// album title, track pages only link to it
+	albumTitle := ""
+	if jsonData.Item_Type == "album" {
+		albumTitle = jsonData.Current.Title
+	} else {
+		rxAlbum := regexp.MustCompile(`<span class="fromAlbum">(.+?)</span>`).FindAllStringSubmatch(pageBody, 1)
+		if len(rxAlbum) > 0 {
+			albumTitle = html.UnescapeString(rxAlbum[0][1])
+		}
+	}
+
+	// get tags
+	var tags []string
+	rxTags := regexp.MustCompile(`<a class="tag"[^>]*>(.+?)</a>`).FindAllStringSubmatch(pageBody, -1)
+	for _, tag := range rxTags {
+		tags = append(tags, html.UnescapeString(tag[1]))
+	}
+
+	releaseDate := jsonData.Album_Release_Date
+	if releaseDate == "" {
+		releaseDate = jsonData.Current.Release_Date
+	}
+
 	// get base URL
 	baseURL := urlRx.FindAllStringSubmatch(url, 1)[0][1]
 
@@ -106,8 +163,36 @@ func parseBandcamp(url string, urlRx *regexp.Regexp) ([]StreamData, error) {
 		songData := StreamData{
 			URL:       baseURL + track.Title_Link,
 			Title:     track.Title,
-			StreamURL: track.File.URL,
+			StreamURL: track.File["mp3-128"],
+			Protocol:  ProtocolHTTPS,
 			ImageURL:  albumArtURL,
+
+			Artist:      jsonData.Artist,
+			Album:       albumTitle,
+			TrackNumber: track.Track_Num,
+			Uploader:    jsonData.Artist,
+			UploadDate:  parseDate(releaseDate),
+			Description: jsonData.Current.About,
+			Tags:        tags,
+		}
+
+		// formats are keyed by encoding, e.g. "mp3-128" or "mp3-v0"
+		for encoding, streamURL := range track.File {
+			songData.Formats = append(songData.Formats, bandcampFormat(encoding, streamURL))
+		}
+		sort.Slice(songData.Formats, func(i int, j int) bool {
+			return formatLess(songData.Formats[i], songData.Formats[j])
+		})
+
+		if songData.StreamURL == "" {
+			if format, ok := songData.BestAudio(); ok {
+				songData.StreamURL = format.URL
+			}
+		}
+
+		// compilations credit each track separately
+		if track.Artist != "" {
+			songData.Artist = track.Artist
 		}
 
 		// parse duration
@@ -120,5 +205,18 @@ func parseBandcamp(url string, urlRx *regexp.Regexp) ([]StreamData, error) {
 		streamData = append(streamData, songData)
 	}
 
-	return streamData, nil
+	result.Entries = streamData
+	if jsonData.Item_Type == "album" {
+		result.Kind = KindAlbum
+		result.Playlist = &PlaylistInfo{
+			URL:         url,
+			Title:       jsonData.Current.Title,
+			Owner:       jsonData.Artist,
+			Description: jsonData.Current.About,
+			ImageURL:    albumArtURL,
+			Count:       len(jsonData.TrackInfo),
+		}
+	}
+
+	return result, nil
 }
@@ -0,0 +1,93 @@
+package StreamTool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cache stores values derived from slow requests so they can be reused.
+// Entries are best effort, a Cache may drop them at any time.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+}
+
+var defaultCache = NewMemoryCache()
+
+// MemoryCache keeps entries for the lifetime of the process.
+type MemoryCache struct {
+	mu      sync.RWMutex
+	entries map[string][]byte
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{entries: map[string][]byte{}}
+}
+
+func (m *MemoryCache) Get(key string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.entries[key]
+	return value, ok
+}
+
+func (m *MemoryCache) Set(key string, value []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = value
+}
+
+// DiskCache keeps entries as files in Dir, so they survive restarts.
+type DiskCache struct {
+	Dir string
+}
+
+// NewDiskCache returns a DiskCache storing entries in dir, which is created
+// when the first entry is written.
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{Dir: dir}
+}
+
+// file name for a key, keys can hold any character
+func (d *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.Dir, hex.EncodeToString(sum[:]))
+}
+
+func (d *DiskCache) Get(key string) ([]byte, bool) {
+	value, err := ioutil.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (d *DiskCache) Set(key string, value []byte) {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return
+	}
+
+	// write then rename so readers never see a partial entry
+	tmp, err := ioutil.TempFile(d.Dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	os.Rename(tmp.Name(), d.path(key))
+}
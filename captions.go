@@ -0,0 +1,211 @@
+package StreamTool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Caption is a subtitle track available for a stream.
+type Caption struct {
+	// language code such as "en" or "pt-BR"
+	Language string
+
+	// name shown to viewers, e.g. "English (auto-generated)"
+	Name string
+
+	// generated by speech recognition rather than written by someone
+	AutoGenerated bool
+
+	URL string
+}
+
+// Cue is a line of text shown during part of a stream.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// ErrCaptions is returned when a caption track can't be read.
+var ErrCaptions = errors.New("invalid captions")
+
+// FetchCaptions downloads track using DefaultClient.
+func FetchCaptions(ctx context.Context, track Caption) ([]Cue, error) {
+	return DefaultClient.FetchCaptions(ctx, track)
+}
+
+// FetchCaptions downloads track and parses its cues. Errors are
+// ExtractErrors, unreadable tracks also match ErrCaptions.
+func (c *Client) FetchCaptions(ctx context.Context, track Caption) ([]Cue, error) {
+	trackURL := track.URL
+
+	// youtube serves xml by default, json3 is easier to read
+	if u, err := url.Parse(trackURL); err == nil && strings.HasSuffix(u.Path, "/api/timedtext") {
+		query := u.Query()
+		query.Set("fmt", "json3")
+		u.RawQuery = query.Encode()
+		trackURL = u.String()
+	}
+
+	resp, err := c.get(ctx, trackURL)
+	if err != nil {
+		return nil, fetchError("captions", StageFetch, "couldn't fetch captions", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fetchError("captions", StageFetch, "couldn't read captions", err)
+	}
+
+	cues, err := ParseTimedText(bytes.NewReader(body))
+	if err != nil {
+		return nil, newExtractError("captions", StageDecode, ErrLayoutChanged, "%w", err)
+	}
+	return cues, nil
+}
+
+type timedTextJSON struct {
+	Events []struct {
+		TStartMs    int64
+		DDurationMs int64
+		Segs        []struct {
+			Utf8 string
+		}
+	}
+}
+
+type timedTextXML struct {
+	// srv1, used by the legacy api
+	Texts []struct {
+		Start string `xml:"start,attr"`
+		Dur   string `xml:"dur,attr"`
+		Text  string `xml:",chardata"`
+	} `xml:"text"`
+
+	// srv3, whose paragraphs can hold styled <s> segments
+	Body struct {
+		Paragraphs []struct {
+			T     int64  `xml:"t,attr"`
+			D     int64  `xml:"d,attr"`
+			Inner string `xml:",innerxml"`
+		} `xml:"p"`
+	} `xml:"body"`
+}
+
+var rxLineBreak = regexp.MustCompile(`<br\s*/?>`)
+var rxTag = regexp.MustCompile(`<[^>]*>`)
+
+// ParseTimedText reads youtube timedtext captions, either json3 or xml.
+// Empty cues are dropped.
+func ParseTimedText(r io.Reader) ([]Cue, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+
+	var cues []Cue
+	add := func(start time.Duration, dur time.Duration, text string) {
+		text = strings.TrimSpace(text)
+		if text != "" {
+			cues = append(cues, Cue{Start: start, End: start + dur, Text: text})
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(body, []byte("{")):
+		var data timedTextJSON
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCaptions, err)
+		}
+
+		for _, event := range data.Events {
+			var text strings.Builder
+			for _, seg := range event.Segs {
+				text.WriteString(seg.Utf8)
+			}
+			add(time.Duration(event.TStartMs)*time.Millisecond, time.Duration(event.DDurationMs)*time.Millisecond, text.String())
+		}
+
+	case bytes.HasPrefix(body, []byte("<")):
+		var data timedTextXML
+		if err := xml.Unmarshal(body, &data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCaptions, err)
+		}
+
+		// srv1 text is escaped twice
+		for _, text := range data.Texts {
+			start, _ := strconv.ParseFloat(text.Start, 64)
+			dur, _ := strconv.ParseFloat(text.Dur, 64)
+			add(time.Duration(start*float64(time.Second)), time.Duration(dur*float64(time.Second)), html.UnescapeString(text.Text))
+		}
+
+		for _, p := range data.Body.Paragraphs {
+			text := rxLineBreak.ReplaceAllString(p.Inner, "\n")
+			text = html.UnescapeString(rxTag.ReplaceAllString(text, ""))
+			add(time.Duration(p.T)*time.Millisecond, time.Duration(p.D)*time.Millisecond, text)
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: unknown format", ErrCaptions)
+	}
+
+	return cues, nil
+}
+
+// format a timestamp as hh:mm:ss followed by sep and milliseconds
+func cueTimestamp(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// a blank line ends a cue early in both formats
+var rxBlankLines = regexp.MustCompile(`\n\s*\n\s*`)
+
+// cue text without blank lines or surrounding whitespace
+func cueText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return rxBlankLines.ReplaceAllString(strings.TrimSpace(text), "\n")
+}
+
+// WriteSRT writes cues as SubRip subtitles.
+func WriteSRT(w io.Writer, cues []Cue) error {
+	for i, cue := range cues {
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, cueTimestamp(cue.Start, ","), cueTimestamp(cue.End, ","), cueText(cue.Text))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// text can't hold markup characters
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// WriteVTT writes cues as WebVTT subtitles.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+
+	for _, cue := range cues {
+		_, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n", cueTimestamp(cue.Start, "."), cueTimestamp(cue.End, "."), vttEscaper.Replace(cueText(cue.Text)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
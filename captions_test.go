@@ -0,0 +1,129 @@
+package StreamTool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimedText(t *testing.T) {
+	want := []Cue{
+		{Start: 1500 * time.Millisecond, End: 4 * time.Second, Text: "Hello & welcome"},
+		{Start: 4 * time.Second, End: 6250 * time.Millisecond, Text: "second\nline"},
+	}
+
+	tests := map[string]string{
+		"json3": `{"wireMagic":"pb3","events":[
+			{"tStartMs":0,"dDurationMs":100000,"id":1,"wpWinPosId":1},
+			{"tStartMs":1500,"dDurationMs":2500,"segs":[{"utf8":"Hello "},{"utf8":"& welcome"}]},
+			{"tStartMs":3000,"segs":[{"utf8":"\n"}]},
+			{"tStartMs":4000,"dDurationMs":2250,"segs":[{"utf8":"second\nline"}]}
+		]}`,
+		"srv1": `<?xml version="1.0" encoding="utf-8" ?><transcript>
+			<text start="1.5" dur="2.5">Hello &amp;amp; welcome</text>
+			<text start="3" dur="1"> </text>
+			<text start="4" dur="2.25">second
+line</text>
+		</transcript>`,
+		"srv3": `<?xml version="1.0" encoding="utf-8" ?><timedtext format="3"><body>
+			<p t="1500" d="2500"><s>Hello</s><s t="500"> &amp;</s> welcome</p>
+			<p t="3000" d="1000"></p>
+			<p t="4000" d="2250">second<br/>line</p>
+		</body></timedtext>`,
+	}
+
+	for name, track := range tests {
+		cues, err := ParseTimedText(strings.NewReader(track))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(cues) != len(want) {
+			t.Errorf("%s: got %d cues, want %d: %+v", name, len(cues), len(want), cues)
+			continue
+		}
+		for i := range cues {
+			if cues[i] != want[i] {
+				t.Errorf("%s: cue %d = %+v, want %+v", name, i, cues[i], want[i])
+			}
+		}
+	}
+
+	for _, track := range []string{"", "WEBVTT", `{"events":`, `<transcript><text>`} {
+		if _, err := ParseTimedText(strings.NewReader(track)); !errors.Is(err, ErrCaptions) {
+			t.Errorf("ParseTimedText(%q) error = %v, want ErrCaptions", track, err)
+		}
+	}
+}
+
+var testCues = []Cue{
+	{Start: 1500 * time.Millisecond, End: 4 * time.Second, Text: "<i>Hi</i> & bye"},
+	{Start: time.Hour + 2*time.Minute + 3*time.Second + 45*time.Millisecond, End: time.Hour + 2*time.Minute + 5*time.Second, Text: "one\n\n\ntwo\r\n\r\nthree\n"},
+}
+
+func TestWriteSRT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSRT(&buf, testCues); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "1\n00:00:01,500 --> 00:00:04,000\n<i>Hi</i> & bye\n\n" +
+		"2\n01:02:03,045 --> 01:02:05,000\none\ntwo\nthree\n\n"
+	if buf.String() != want {
+		t.Errorf("WriteSRT = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteVTT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteVTT(&buf, testCues); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "WEBVTT\n\n" +
+		"00:00:01.500 --> 00:00:04.000\n&lt;i&gt;Hi&lt;/i&gt; &amp; bye\n\n" +
+		"01:02:03.045 --> 01:02:05.000\none\ntwo\nthree\n\n"
+	if buf.String() != want {
+		t.Errorf("WriteVTT = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFetchCaptions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/timedtext" && r.URL.Query().Get("fmt") == "json3":
+			fmt.Fprint(w, `{"events":[{"tStartMs":0,"dDurationMs":1000,"segs":[{"utf8":"hi"}]}]}`)
+		case r.URL.Path == "/broken":
+			fmt.Fprint(w, "WEBVTT")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURLs: map[string]string{"www.youtube.com": srv.URL}}
+	cues, err := c.FetchCaptions(context.Background(), Caption{URL: "https://www.youtube.com/api/timedtext?v=x&lang=en&fmt=srv3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 1 || cues[0].Text != "hi" {
+		t.Errorf("cues = %+v, want the json3 track", cues)
+	}
+
+	var extractErr *ExtractError
+	_, err = c.FetchCaptions(context.Background(), Caption{URL: "https://www.youtube.com/missing"})
+	if !errors.Is(err, ErrUnavailable) || !errors.As(err, &extractErr) || extractErr.Stage != StageFetch {
+		t.Errorf("missing track error = %v, want a fetch ExtractError matching ErrUnavailable", err)
+	}
+
+	_, err = c.FetchCaptions(context.Background(), Caption{URL: "https://www.youtube.com/broken"})
+	if !errors.Is(err, ErrCaptions) || !errors.As(err, &extractErr) || extractErr.Stage != StageDecode {
+		t.Errorf("unreadable track error = %v, want a decode ExtractError matching ErrCaptions", err)
+	}
+}
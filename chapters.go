@@ -0,0 +1,119 @@
+package StreamTool
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Chapter is a titled section of a stream, in seconds from its start.
+type Chapter struct {
+	Title string
+	Start int
+
+	// 0 when the chapter runs to the end of a stream of unknown length
+	End int
+}
+
+// SplitChapters expands d into one entry per chapter, each playing only its
+// section of the stream through StartOffset and EndOffset. Without chapters
+// d is returned on its own.
+func (d StreamData) SplitChapters() []StreamData {
+	if len(d.Chapters) == 0 {
+		return []StreamData{d}
+	}
+
+	entries := make([]StreamData, 0, len(d.Chapters))
+	for i, chapter := range d.Chapters {
+		entry := d
+		entry.Title = chapter.Title
+		entry.Album = d.Title
+		entry.TrackNumber = i + 1
+		entry.StartOffset = chapter.Start
+		entry.EndOffset = chapter.End
+		entry.Chapters = nil
+
+		entry.Duration = 0
+		if chapter.End > chapter.Start {
+			entry.Duration = chapter.End - chapter.Start
+		}
+
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// description lines such as "0:00 Intro", "[1:02:03] - Song" or "Song 4:20"
+var rxChapterLines = []*regexp.Regexp{
+	regexp.MustCompile(`^[\[(]?((?:\d+:)?\d{1,2}:\d{2})[\])]?\s*[-–—:|.]?\s*(.+)$`),
+	regexp.MustCompile(`^(.+?)\s*[-–—:|]?\s*[\[(]?((?:\d+:)?\d{1,2}:\d{2})[\])]?$`),
+}
+
+// find chapters listed one per line in a description, which like youtube's
+// own only count when the first starts at 0:00 and there are at least two
+func parseDescriptionChapters(description string, duration int) []Chapter {
+	var chapters []Chapter
+
+	for _, line := range strings.Split(description, "\n") {
+		line = strings.TrimSpace(line)
+
+		for i, rx := range rxChapterLines {
+			match := rx.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+
+			timestamp, title := match[1], match[2]
+			if i == 1 {
+				timestamp, title = title, timestamp
+			}
+
+			title = strings.TrimSpace(title)
+			if title == "" {
+				break
+			}
+
+			// chapters are listed in order, later times are other mentions
+			start := parseClockDuration(timestamp)
+			if len(chapters) == 0 || start > chapters[len(chapters)-1].Start {
+				chapters = append(chapters, Chapter{Title: title, Start: start})
+			}
+			break
+		}
+	}
+
+	if len(chapters) < 2 || chapters[0].Start != 0 {
+		return nil
+	}
+
+	return finishChapters(chapters, duration)
+}
+
+// sort chapters, drop those out of range and end each where the next starts
+func finishChapters(chapters []Chapter, duration int) []Chapter {
+	sort.SliceStable(chapters, func(i int, j int) bool {
+		return chapters[i].Start < chapters[j].Start
+	})
+
+	var finished []Chapter
+	for _, chapter := range chapters {
+		if duration > 0 && chapter.Start >= duration {
+			continue
+		}
+		// two chapters at the same time leave nothing for the first
+		if len(finished) > 0 && finished[len(finished)-1].Start == chapter.Start {
+			finished = finished[:len(finished)-1]
+		}
+		finished = append(finished, chapter)
+	}
+
+	for i := range finished {
+		if i+1 < len(finished) {
+			finished[i].End = finished[i+1].Start
+		} else {
+			finished[i].End = duration
+		}
+	}
+
+	return finished
+}
@@ -0,0 +1,151 @@
+package StreamTool
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseDescriptionChapters(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		duration    int
+		want        []Chapter
+	}{
+		{"timestamp first", "Tracklist:\n0:00 Intro\n1:30 - Verse\n[2:45] Chorus\n(1:02:03) | Outro\n", 4000,
+			[]Chapter{{"Intro", 0, 90}, {"Verse", 90, 165}, {"Chorus", 165, 3723}, {"Outro", 3723, 4000}}},
+		{"title first", "Intro 0:00\nVerse - 1:30\nChorus [2:45]\n", 200,
+			[]Chapter{{"Intro", 0, 90}, {"Verse", 90, 165}, {"Chorus", 165, 200}}},
+		{"mixed", "00:00 Intro\nThe end 3:00", 0,
+			[]Chapter{{"Intro", 0, 180}, {"The end", 180, 0}}},
+		{"later mentions", "0:00 Intro\n2:00 Song\nmy favourite part is at 1:00\n3:00 Outro", 240,
+			[]Chapter{{"Intro", 0, 120}, {"Song", 120, 180}, {"Outro", 180, 240}}},
+		{"past the end", "0:00 Intro\n1:00 Song\n9:00 Bonus", 300,
+			[]Chapter{{"Intro", 0, 60}, {"Song", 60, 300}}},
+		{"not from the start", "0:30 Intro\n1:00 Song", 120, nil},
+		{"single", "0:00 Everything", 120, nil},
+		{"no titles", "0:00\n1:00", 120, nil},
+		{"none", "Thanks for watching!\nFollow me at example.com", 120, nil},
+	}
+
+	for _, test := range tests {
+		got := parseDescriptionChapters(test.description, test.duration)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: chapters = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFinishChapters(t *testing.T) {
+	chapters := []Chapter{
+		{Title: "C", Start: 120},
+		{Title: "A", Start: 0},
+		{Title: "B1", Start: 60},
+		{Title: "B2", Start: 60},
+		{Title: "late", Start: 300},
+	}
+
+	want := []Chapter{{"A", 0, 60}, {"B2", 60, 120}, {"C", 120, 300}}
+	if got := finishChapters(chapters, 300); !reflect.DeepEqual(got, want) {
+		t.Errorf("chapters = %+v, want %+v", got, want)
+	}
+
+	// without a duration nothing is dropped and the last runs to the end
+	chapters = []Chapter{{Title: "B", Start: 60}, {Title: "A", Start: 0}}
+	want = []Chapter{{"A", 0, 60}, {"B", 60, 0}}
+	if got := finishChapters(chapters, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("chapters without a duration = %+v, want %+v", got, want)
+	}
+}
+
+func TestSplitChapters(t *testing.T) {
+	data := StreamData{URL: "u", Title: "Mix", StreamURL: "s", Duration: 300, Chapters: []Chapter{{"A", 0, 60}, {"B", 60, 300}}}
+
+	entries := data.SplitChapters()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	for i, want := range []struct {
+		title              string
+		start, end, length int
+	}{{"A", 0, 60, 60}, {"B", 60, 300, 240}} {
+		entry := entries[i]
+		if entry.Title != want.title || entry.StartOffset != want.start || entry.EndOffset != want.end || entry.Duration != want.length {
+			t.Errorf("entry %d = %q %d-%d (%ds), want %q %d-%d (%ds)", i, entry.Title, entry.StartOffset, entry.EndOffset, entry.Duration, want.title, want.start, want.end, want.length)
+		}
+		if entry.Album != "Mix" || entry.TrackNumber != i+1 || entry.StreamURL != "s" || entry.Chapters != nil {
+			t.Errorf("entry %d = %+v, want track %d of Mix sharing its stream", i, entry, i+1)
+		}
+	}
+
+	// the last chapter of a stream of unknown length has no end
+	live := StreamData{Chapters: []Chapter{{"A", 0, 30}, {"B", 30, 0}}}
+	if last := live.SplitChapters()[1]; last.EndOffset != 0 || last.Duration != 0 {
+		t.Errorf("open ended chapter = %d-%d (%ds), want 30-0 (0s)", last.StartOffset, last.EndOffset, last.Duration)
+	}
+
+	if entries := (StreamData{Title: "x"}).SplitChapters(); len(entries) != 1 || entries[0].Title != "x" {
+		t.Errorf("without chapters = %+v, want the entry itself", entries)
+	}
+}
+
+// ytInitialData with auto generated chapters listed before the uploader's
+const testMarkerPage = `{"engagementPanels": [
+	{"engagementPanelSectionListRenderer": {"panelIdentifier": "engagement-panel-structured-description"}},
+	{"engagementPanelSectionListRenderer": {"panelIdentifier": "engagement-panel-macro-markers-auto-chapters", "content": {"macroMarkersListRenderer": {"contents": [
+		{"macroMarkersListItemRenderer": {"title": {"simpleText": "Auto"}, "onTap": {"watchEndpoint": {"startTimeSeconds": 0}}}}
+	]}}}},
+	{"engagementPanelSectionListRenderer": {"panelIdentifier": "engagement-panel-macro-markers-description-chapters", "content": {"macroMarkersListRenderer": {"contents": [
+		{"macroMarkersListItemRenderer": {"title": {"simpleText": "Marker intro"}, "onTap": {"watchEndpoint": {}}}},
+		{"macroMarkersListItemRenderer": {"title": {"runs": [{"text": "Marker "}, {"text": "song"}]}, "onTap": {"watchEndpoint": {"startTimeSeconds": 100}}}},
+		{"macroMarkersListItemRenderer": {"title": {"simpleText": " "}, "onTap": {"watchEndpoint": {"startTimeSeconds": 150}}}}
+	]}}}}
+]}`
+
+func TestYoutubeMarkerChapters(t *testing.T) {
+	want := []Chapter{{"Marker intro", 0, 100}, {"Marker song", 100, 200}}
+	if got := youtubeMarkerChapters(testMarkerPage, 200); !reflect.DeepEqual(got, want) {
+		t.Errorf("chapters = %+v, want %+v", got, want)
+	}
+
+	if got := youtubeMarkerChapters(`{"engagementPanels": []}`, 200); got != nil {
+		t.Errorf("chapters without markers = %+v, want none", got)
+	}
+	if got := youtubeMarkerChapters(`{"engagementPanels": `, 200); got != nil {
+		t.Errorf("chapters of a broken page = %+v, want none", got)
+	}
+}
+
+func TestYoutubeWebpageChapters(t *testing.T) {
+	playerResponse := `{"playabilityStatus": {"status": "OK"},
+		"streamingData": {"formats": [{"itag": 18, "url": "https://rr1.googlevideo.com/videoplayback?itag=18", "mimeType": "video/mp4; codecs=\"avc1.42001E, mp4a.40.2\"", "bitrate": 500000}]},
+		"videoDetails": {"title": "Mix", "lengthSeconds": "200", "shortDescription": "0:00 Description intro\n0:50 Description song"}}`
+
+	for _, test := range []struct {
+		name string
+		data string
+		want []Chapter
+	}{
+		{"markers", testMarkerPage, []Chapter{{"Marker intro", 0, 100}, {"Marker song", 100, 200}}},
+		{"description", `{"engagementPanels": []}`, []Chapter{{"Description intro", 0, 50}, {"Description song", 50, 200}}},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<html><script>var ytInitialPlayerResponse = %s;</script><script>var ytInitialData = %s;</script></html>`, playerResponse, test.data)
+		}))
+
+		c := &Client{BaseURLs: map[string]string{"www.youtube.com": srv.URL}}
+		streamData, err := c.parseYoutubeWebpage(context.Background(), "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ")
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(streamData[0].Chapters, test.want) {
+			t.Errorf("%s: chapters = %+v, want %+v", test.name, streamData[0].Chapters, test.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package StreamTool
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client holds the settings used while resolving urls. The zero value is
+// ready to use and behaves like the package level functions.
+type Client struct {
+	// used for every request, defaults to a client with a 10 second timeout
+	HTTPClient *http.Client
+
+	// sent with every request when not empty
+	UserAgent      string
+	AcceptLanguage string
+
+	// redirects requests for a host to another base url, mainly useful for
+	// proxies and tests, e.g. {"www.youtube.com": "http://127.0.0.1:8080"}
+	BaseURLs map[string]string
+
+	// client profiles tried in order with youtube's api when the watch page
+	// fails, defaults to DefaultYoutubeClients
+	YoutubeClients []YoutubeClient
+
+	// go straight to the api instead of scraping the watch page first
+	YoutubeSkipWebpage bool
+
+	// format selector picking a soundcloud track's StreamURL, see SelectFormat.
+	// Each stream costs a request to resolve, so only the chosen one is and
+	// it's the only one in Formats. Defaults to DefaultSoundcloudFormat
+	SoundcloudFormat string
+
+	// stop reading a playlist after this many entries, 0 reads all of it
+	MaxPlaylistItems int
+
+	// stores what's expensive to derive, such as the youtube player and the
+	// soundcloud client id, across calls. Defaults to a memory cache shared by
+	// every client, use a DiskCache to keep them between runs
+	Cache Cache
+}
+
+// DefaultClient is used by ParseURL and ParseURLContext.
+var DefaultClient = &Client{}
+
+// ParseURL resolves a supported url into its stream data.
+func (c *Client) ParseURL(url string) ([]StreamData, error) {
+	return c.ParseURLContext(context.Background(), url)
+}
+
+// ParseURLContext is like ParseURL but every request made while resolving the
+// url is bound to ctx, so a single deadline or cancellation covers all of them.
+func (c *Client) ParseURLContext(ctx context.Context, url string) ([]StreamData, error) {
+	result, err := c.ResolveContext(ctx, url)
+	if result == nil {
+		return []StreamData{}, err
+	}
+	return result.Entries, err
+}
+
+// Resolve is like ParseURL but also describes what the url points to, such as
+// the playlist or album the entries belong to.
+func (c *Client) Resolve(url string) (*Result, error) {
+	return c.ResolveContext(context.Background(), url)
+}
+
+// ResolveContext is like Resolve with every request bound to ctx.
+func (c *Client) ResolveContext(ctx context.Context, url string) (*Result, error) {
+	extractor := findExtractor(url)
+	if extractor == nil {
+		return &Result{}, ErrUnsupportedURL
+	}
+
+	return extractor.Extract(ctx, c, url)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return netClient
+}
+
+func (c *Client) cache() Cache {
+	if c.Cache != nil {
+		return c.Cache
+	}
+	return defaultCache
+}
+
+// swap the scheme and host for the configured base url, if any
+func (c *Client) rewriteURL(rawURL string) string {
+	if len(c.BaseURLs) == 0 {
+		return rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	base, ok := c.BaseURLs[u.Host]
+	if !ok {
+		return rawURL
+	}
+
+	b, err := url.Parse(base)
+	if err != nil {
+		return rawURL
+	}
+
+	u.Scheme = b.Scheme
+	u.Host = b.Host
+	u.Path = strings.TrimSuffix(b.Path, "/") + u.Path
+	u.RawPath = ""
+	return u.String()
+}
+
+// build a request with the client's headers applied
+func (c *Client) newRequest(ctx context.Context, method string, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.rewriteURL(url), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	if c.AcceptLanguage != "" {
+		req.Header.Set("Accept-Language", c.AcceptLanguage)
+	}
+
+	return req, nil
+}
+
+// GET request bound to ctx so cancelling it aborts the transfer
+func (c *Client) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(req)
+}
+
+// send req, treating a non 2xx status as an error
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, &statusError{resp.StatusCode, resp.Status}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,112 @@
+package StreamTool
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sentinel errors describing why an extraction failed, match them with
+// errors.Is.
+var (
+	ErrUnsupportedURL = errors.New("not an accepted url")
+	ErrUnavailable    = errors.New("content unavailable")
+	ErrPrivate        = errors.New("content is private")
+	ErrGeoBlocked     = errors.New("content is blocked in this region")
+	ErrAgeRestricted  = errors.New("content is age restricted")
+	ErrMembersOnly    = errors.New("content is for members only")
+	ErrPreviewOnly    = errors.New("only a preview is available")
+	ErrRateLimited    = errors.New("rate limited")
+	ErrLayoutChanged  = errors.New("site layout changed")
+	ErrNetwork        = errors.New("network failure")
+)
+
+// Stage is the step of an extraction that failed.
+type Stage string
+
+const (
+	StageFetch        Stage = "fetch"
+	StageLocateJSON   Stage = "locate-json"
+	StageDecode       Stage = "decode"
+	StageDecipher     Stage = "decipher"
+	StageStreamLookup Stage = "stream-lookup"
+	StagePlayability  Stage = "playability"
+)
+
+// ExtractError is returned by the extractors. Kind holds one of the sentinel
+// errors above (or nil when the failure doesn't fit any of them) so that
+// errors.Is(err, ErrLayoutChanged) and friends work, while Err is the
+// underlying cause.
+type ExtractError struct {
+	Provider   string
+	Stage      Stage
+	StatusCode int
+	Kind       error
+	Err        error
+
+	// explanation given by the site, e.g. "This video is private"
+	Reason string
+}
+
+func (e *ExtractError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Provider, e.Stage, e.Err)
+}
+
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ExtractError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// returned by Client.get for responses outside the 2xx range
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status " + e.status
+}
+
+// build an ExtractError with the message formatted like fmt.Errorf
+func newExtractError(provider string, stage Stage, kind error, format string, args ...interface{}) error {
+	return &ExtractError{
+		Provider: provider,
+		Stage:    stage,
+		Kind:     kind,
+		Err:      fmt.Errorf(format, args...),
+	}
+}
+
+// wrap a failed request, classifying it by status code when there was a
+// response and as a network failure otherwise
+func fetchError(provider string, stage Stage, msg string, err error) error {
+	extractErr := &ExtractError{
+		Provider: provider,
+		Stage:    stage,
+		Kind:     ErrNetwork,
+		Err:      fmt.Errorf("%s: %w", msg, err),
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		extractErr.StatusCode = statusErr.code
+
+		switch {
+		case statusErr.code == http.StatusNotFound || statusErr.code == http.StatusGone:
+			extractErr.Kind = ErrUnavailable
+		case statusErr.code == http.StatusUnavailableForLegalReasons:
+			extractErr.Kind = ErrGeoBlocked
+		case statusErr.code == http.StatusTooManyRequests:
+			extractErr.Kind = ErrRateLimited
+		case statusErr.code >= 500:
+			extractErr.Kind = ErrNetwork
+		default:
+			extractErr.Kind = nil
+		}
+	}
+
+	return extractErr
+}
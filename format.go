@@ -0,0 +1,305 @@
+package StreamTool
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is one of the streams a StreamData can be played from.
+type Format struct {
+	URL        string
+	MimeType   string
+	Codec      string
+	Container  string
+	Bitrate    int
+	SampleRate int
+	HasAudio   bool
+	HasVideo   bool
+	Width      int
+	Height     int
+	Filesize   int64
+
+	// how URL is served, one of the Protocol constants
+	Protocol string
+
+	// the url couldn't be descrambled and will likely download slowly
+	Throttled bool
+}
+
+// Protocols a Format or StreamURL can be served over.
+const (
+	// a single file downloaded over http
+	ProtocolHTTPS = "https"
+
+	// m3u8 playlists, see ParseHLS
+	ProtocolHLS = "hls"
+
+	// mpd manifests, see ParseDASH
+	ProtocolDASH = "dash"
+)
+
+// ErrNoFormat is returned when no format satisfies a selector.
+var ErrNoFormat = errors.New("no format matches selector")
+
+// split a mime type such as `audio/webm; codecs="opus"` into container and codecs
+func parseMimeType(mimeType string) (container string, codec string) {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return "", ""
+	}
+
+	container = mediaType[strings.Index(mediaType, "/")+1:]
+	switch container {
+	case "mp4":
+		if strings.HasPrefix(mediaType, "audio/") {
+			container = "m4a"
+		}
+	case "mpeg":
+		container = "mp3"
+	case "ogg":
+		if strings.Contains(params["codecs"], "opus") {
+			container = "opus"
+		}
+	}
+
+	return container, params["codecs"]
+}
+
+// BestAudio returns the highest quality audio only format.
+func (d StreamData) BestAudio() (Format, bool) {
+	return d.selectFormat("bestaudio")
+}
+
+// WorstAudio returns the lowest quality audio only format.
+func (d StreamData) WorstAudio() (Format, bool) {
+	return d.selectFormat("worstaudio")
+}
+
+// BestVideo returns the highest quality video only format.
+func (d StreamData) BestVideo() (Format, bool) {
+	return d.selectFormat("bestvideo")
+}
+
+// WorstVideo returns the lowest quality video only format.
+func (d StreamData) WorstVideo() (Format, bool) {
+	return d.selectFormat("worstvideo")
+}
+
+// Select picks a format using a selector expression, see SelectFormat.
+func (d StreamData) Select(selector string) (Format, error) {
+	return SelectFormat(d.Formats, selector)
+}
+
+func (d StreamData) selectFormat(selector string) (Format, bool) {
+	format, err := SelectFormat(d.Formats, selector)
+	return format, err == nil
+}
+
+// SelectFormat picks a format with a selector such as "bestaudio[ext=m4a]/best".
+//
+// Alternatives are separated by '/' and tried from left to right. Each one is
+// best, worst, bestaudio, worstaudio, bestvideo or worstvideo followed by any
+// number of [key op value] filters. The keys are ext, codec, mime, proto,
+// bitrate (in kbps), asr, width, height and filesize, the operators are =, !=,
+// <, <=, >, >=, ^= (prefix), $= (suffix) and *= (contains).
+func SelectFormat(formats []Format, selector string) (Format, error) {
+	for _, alt := range splitAlternatives(selector) {
+		format, err := selectAlternative(formats, strings.TrimSpace(alt))
+		if err == nil {
+			return format, nil
+		}
+		if !errors.Is(err, ErrNoFormat) {
+			return Format{}, err
+		}
+	}
+
+	return Format{}, ErrNoFormat
+}
+
+// split a selector on the slashes outside of filters, which can hold mime
+// types such as audio/mp4
+func splitAlternatives(selector string) []string {
+	var alts []string
+	depth := 0
+	start := 0
+	for i, r := range selector {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			if depth > 0 {
+				depth--
+			}
+		case '/':
+			if depth == 0 {
+				alts = append(alts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(alts, selector[start:])
+}
+
+var rxSelector = regexp.MustCompile(`^(best|worst)(audio|video)?((?:\[[^\]]+\])*)$`)
+var rxSelectorFilter = regexp.MustCompile(`\[\s*(\w+)\s*(!=|<=|>=|\^=|\$=|\*=|=|<|>)\s*([^\]]*?)\s*\]`)
+
+func selectAlternative(formats []Format, alt string) (Format, error) {
+	match := rxSelector.FindStringSubmatch(alt)
+	if match == nil {
+		return Format{}, fmt.Errorf("invalid format selector %q", alt)
+	}
+	best := match[1] == "best"
+	kind := match[2]
+
+	filters := rxSelectorFilter.FindAllStringSubmatch(match[3], -1)
+	if len(filters) != strings.Count(match[3], "[") {
+		return Format{}, fmt.Errorf("invalid format filter in %q", alt)
+	}
+
+	var candidates []Format
+	for _, format := range formats {
+		switch kind {
+		case "audio":
+			if !format.HasAudio || format.HasVideo {
+				continue
+			}
+		case "video":
+			if !format.HasVideo || format.HasAudio {
+				continue
+			}
+		}
+
+		ok, err := matchFilters(format, filters)
+		if err != nil {
+			return Format{}, err
+		}
+		if ok {
+			candidates = append(candidates, format)
+		}
+	}
+
+	// plain best/worst prefers formats carrying both audio and video, but
+	// audio only sites have none of those
+	if kind == "" {
+		var muxed []Format
+		for _, format := range candidates {
+			if format.HasAudio && format.HasVideo {
+				muxed = append(muxed, format)
+			}
+		}
+		if len(muxed) > 0 {
+			candidates = muxed
+		}
+	}
+
+	if len(candidates) == 0 {
+		return Format{}, ErrNoFormat
+	}
+
+	sort.SliceStable(candidates, func(i int, j int) bool {
+		return formatLess(candidates[i], candidates[j])
+	})
+
+	if best {
+		return candidates[len(candidates)-1], nil
+	}
+	return candidates[0], nil
+}
+
+// order formats by resolution, then bitrate, then sample rate
+func formatLess(a Format, b Format) bool {
+	if a.Height != b.Height {
+		return a.Height < b.Height
+	}
+	if a.Bitrate != b.Bitrate {
+		return a.Bitrate < b.Bitrate
+	}
+	if a.SampleRate != b.SampleRate {
+		return a.SampleRate < b.SampleRate
+	}
+	return a.Filesize < b.Filesize
+}
+
+func matchFilters(format Format, filters [][]string) (bool, error) {
+	for _, filter := range filters {
+		key, op, value := filter[1], filter[2], filter[3]
+
+		var str string
+		var num int64
+		numeric := true
+
+		switch key {
+		case "ext", "container":
+			str, numeric = format.Container, false
+		case "codec":
+			str, numeric = format.Codec, false
+		case "mime":
+			str, numeric = format.MimeType, false
+		case "proto", "protocol":
+			str, numeric = format.Protocol, false
+		case "bitrate":
+			num = int64(format.Bitrate / 1000)
+		case "asr":
+			num = int64(format.SampleRate)
+		case "width":
+			num = int64(format.Width)
+		case "height":
+			num = int64(format.Height)
+		case "filesize":
+			num = format.Filesize
+		default:
+			return false, fmt.Errorf("unknown format filter key %q", key)
+		}
+
+		var ok bool
+		if numeric {
+			target, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return false, fmt.Errorf("invalid number in format filter %q: %w", filter[0], err)
+			}
+
+			switch op {
+			case "=":
+				ok = num == target
+			case "!=":
+				ok = num != target
+			case "<":
+				ok = num < target
+			case "<=":
+				ok = num <= target
+			case ">":
+				ok = num > target
+			case ">=":
+				ok = num >= target
+			default:
+				return false, fmt.Errorf("operator %s can't be used with %s", op, key)
+			}
+		} else {
+			switch op {
+			case "=":
+				ok = str == value
+			case "!=":
+				ok = str != value
+			case "^=":
+				ok = strings.HasPrefix(str, value)
+			case "$=":
+				ok = strings.HasSuffix(str, value)
+			case "*=":
+				ok = strings.Contains(str, value)
+			default:
+				return false, fmt.Errorf("operator %s can't be used with %s", op, key)
+			}
+		}
+
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,105 @@
+package StreamTool
+
+import (
+	"errors"
+	"testing"
+)
+
+var testFormats = []Format{
+	{URL: "m4a-128", MimeType: `audio/mp4; codecs="mp4a.40.2"`, Codec: "mp4a.40.2", Container: "m4a", Bitrate: 128000, SampleRate: 44100, HasAudio: true, Protocol: ProtocolHTTPS},
+	{URL: "opus-160", MimeType: `audio/webm; codecs="opus"`, Codec: "opus", Container: "webm", Bitrate: 160000, SampleRate: 48000, HasAudio: true, Protocol: ProtocolHTTPS},
+	{URL: "mp4-360", MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, Codec: "avc1.42001E, mp4a.40.2", Container: "mp4", Bitrate: 500000, HasAudio: true, HasVideo: true, Width: 640, Height: 360, Protocol: ProtocolHTTPS},
+	{URL: "webm-1080", MimeType: `video/webm; codecs="vp9"`, Codec: "vp9", Container: "webm", Bitrate: 2500000, HasVideo: true, Width: 1920, Height: 1080, Protocol: ProtocolHTTPS},
+	{URL: "hls-720", MimeType: "application/vnd.apple.mpegurl", Container: "m3u8", Bitrate: 1500000, HasAudio: true, HasVideo: true, Width: 1280, Height: 720, Protocol: ProtocolHLS},
+}
+
+func TestSelectFormat(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		{"best", "hls-720"},
+		{"worst", "mp4-360"},
+		{"bestaudio", "opus-160"},
+		{"worstaudio", "m4a-128"},
+		{"bestvideo", "webm-1080"},
+		{"bestaudio[ext=m4a]", "m4a-128"},
+		{"best[ext!=m3u8]", "mp4-360"},
+		{"bestaudio[mime^=audio/mp4]", "m4a-128"},
+		{"best[mime*=video/webm]", "webm-1080"},
+		{"bestaudio[codec$=opus]", "opus-160"},
+		{"best[proto=https]", "mp4-360"},
+		{"bestaudio[bitrate<150]", "m4a-128"},
+		{"bestaudio[asr>=48000]", "opus-160"},
+		{"best[height<=480][width>0]", "mp4-360"},
+		{"bestaudio[ext=mp3]/bestaudio[mime^=audio/mp4]", "m4a-128"},
+		{"bestaudio[ext=mp3] / bestvideo[height>=1080]", "webm-1080"},
+		{"bestaudio[ext=flac]/best[proto=hls]", "hls-720"},
+	}
+
+	for _, test := range tests {
+		format, err := SelectFormat(testFormats, test.selector)
+		if err != nil {
+			t.Errorf("SelectFormat(%q) returned error: %v", test.selector, err)
+			continue
+		}
+		if format.URL != test.want {
+			t.Errorf("SelectFormat(%q) = %s, want %s", test.selector, format.URL, test.want)
+		}
+	}
+}
+
+func TestSelectFormatNoMatch(t *testing.T) {
+	for _, selector := range []string{"bestaudio[ext=mp3]", "best[height>2160]/worstaudio[asr>96000]"} {
+		_, err := SelectFormat(testFormats, selector)
+		if !errors.Is(err, ErrNoFormat) {
+			t.Errorf("SelectFormat(%q) error = %v, want ErrNoFormat", selector, err)
+		}
+	}
+
+	if _, err := SelectFormat(nil, "best"); !errors.Is(err, ErrNoFormat) {
+		t.Errorf("SelectFormat(nil) error = %v, want ErrNoFormat", err)
+	}
+}
+
+func TestSelectFormatInvalid(t *testing.T) {
+	for _, selector := range []string{
+		"greatest",
+		"best[size=1]",
+		"best[height=tall]",
+		"best[ext<m4a]",
+		"best[height^=1]",
+		"best[ext=m4a",
+	} {
+		_, err := SelectFormat(testFormats, selector)
+		if err == nil || errors.Is(err, ErrNoFormat) {
+			t.Errorf("SelectFormat(%q) error = %v, want a syntax error", selector, err)
+		}
+	}
+}
+
+func TestSplitAlternatives(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"best", []string{"best"}},
+		{"bestaudio/best", []string{"bestaudio", "best"}},
+		{"bestaudio[mime^=audio/mp4]/best", []string{"bestaudio[mime^=audio/mp4]", "best"}},
+		{"best[mime=a/b][ext=c]/worst[mime=d/e]", []string{"best[mime=a/b][ext=c]", "worst[mime=d/e]"}},
+	}
+
+	for _, test := range tests {
+		got := splitAlternatives(test.selector)
+		if len(got) != len(test.want) {
+			t.Errorf("splitAlternatives(%q) = %q, want %q", test.selector, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("splitAlternatives(%q) = %q, want %q", test.selector, got, test.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,1227 @@
+package jsinterp
+
+import (
+	"math"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type nativeFunc func(vm *VM, this Value, args []Value) (Value, error)
+
+func native(name string, fn nativeFunc) *Function {
+	return &Function{name: name, native: fn}
+}
+
+// method tables for the primitive and builtin types, filled in init to avoid
+// an initialization cycle through the natives that use them
+var (
+	stringMethods   map[string]*Function
+	arrayMethods    map[string]*Function
+	objectMethods   map[string]*Function
+	functionMethods map[string]*Function
+	numberMethods   map[string]*Function
+	regexpMethods   map[string]*Function
+	dateMethods     map[string]*Function
+)
+
+func init() {
+	stringMethods = map[string]*Function{
+		"split":       native("split", stringSplit),
+		"charAt":      native("charAt", stringCharAt),
+		"charCodeAt":  native("charCodeAt", stringCharCodeAt),
+		"codePointAt": native("codePointAt", stringCharCodeAt),
+		"indexOf":     native("indexOf", stringIndexOf),
+		"lastIndexOf": native("lastIndexOf", stringLastIndexOf),
+		"includes":    native("includes", stringIncludes),
+		"startsWith":  native("startsWith", stringStartsWith),
+		"endsWith":    native("endsWith", stringEndsWith),
+		"slice":       native("slice", stringSlice),
+		"substring":   native("substring", stringSubstring),
+		"substr":      native("substr", stringSubstr),
+		"toUpperCase": native("toUpperCase", stringToUpper),
+		"toLowerCase": native("toLowerCase", stringToLower),
+		"trim":        native("trim", stringTrim),
+		"concat":      native("concat", stringConcat),
+		"repeat":      native("repeat", stringRepeat),
+		"replace":     native("replace", stringReplace(false)),
+		"replaceAll":  native("replaceAll", stringReplace(true)),
+		"match":       native("match", stringMatch),
+		"toString":    native("toString", stringValueOf),
+		"valueOf":     native("valueOf", stringValueOf),
+	}
+
+	arrayMethods = map[string]*Function{
+		"push":        native("push", arrayPush),
+		"pop":         native("pop", arrayPop),
+		"shift":       native("shift", arrayShift),
+		"unshift":     native("unshift", arrayUnshift),
+		"splice":      native("splice", arraySplice),
+		"reverse":     native("reverse", arrayReverse),
+		"slice":       native("slice", arraySlice),
+		"join":        native("join", arrayJoin),
+		"indexOf":     native("indexOf", arrayIndexOf),
+		"lastIndexOf": native("lastIndexOf", arrayLastIndexOf),
+		"includes":    native("includes", arrayIncludes),
+		"concat":      native("concat", arrayConcat),
+		"forEach":     native("forEach", arrayIterate("forEach")),
+		"map":         native("map", arrayIterate("map")),
+		"filter":      native("filter", arrayIterate("filter")),
+		"some":        native("some", arrayIterate("some")),
+		"every":       native("every", arrayIterate("every")),
+		"find":        native("find", arrayIterate("find")),
+		"findIndex":   native("findIndex", arrayIterate("findIndex")),
+		"reduce":      native("reduce", arrayReduce),
+		"sort":        native("sort", arraySort),
+		"fill":        native("fill", arrayFill),
+		"toString":    native("toString", arrayToString),
+	}
+
+	objectMethods = map[string]*Function{
+		"hasOwnProperty": native("hasOwnProperty", objectHasOwnProperty),
+		"toString":       native("toString", objectToString),
+		"valueOf":        native("valueOf", objectValueOf),
+	}
+
+	functionMethods = map[string]*Function{
+		"call":  native("call", functionCall),
+		"apply": native("apply", functionApply),
+		"bind":  native("bind", functionBind),
+	}
+
+	numberMethods = map[string]*Function{
+		"toString": native("toString", numberToStringMethod),
+		"toFixed":  native("toFixed", numberToFixed),
+		"valueOf":  native("valueOf", objectValueOf),
+	}
+
+	regexpMethods = map[string]*Function{
+		"test": native("test", regexpTest),
+		"exec": native("exec", regexpExec),
+	}
+
+	dateMethods = map[string]*Function{
+		"getTime": native("getTime", dateGetTime),
+		"valueOf": native("valueOf", dateGetTime),
+	}
+}
+
+func installBuiltins(vm *VM) {
+	g := vm.global.vars
+
+	g["NaN"] = math.NaN()
+	g["Infinity"] = math.Inf(1)
+
+	str := native("String", func(vm *VM, this Value, args []Value) (Value, error) {
+		if len(args) == 0 {
+			return "", nil
+		}
+		if arr, ok := args[0].(*Array); ok {
+			return vm.primitive(arr)
+		}
+		return toString(args[0]), nil
+	})
+	str.properties().set("fromCharCode", native("fromCharCode", func(vm *VM, this Value, args []Value) (Value, error) {
+		units := make([]uint16, len(args))
+		for i, arg := range args {
+			units[i] = uint16(toUint32(arg))
+		}
+		return fromUTF16(units), nil
+	}))
+	str.properties().set("fromCodePoint", native("fromCodePoint", func(vm *VM, this Value, args []Value) (Value, error) {
+		var sb strings.Builder
+		for _, arg := range args {
+			sb.WriteRune(rune(toInteger(arg)))
+		}
+		return sb.String(), nil
+	}))
+	g["String"] = str
+
+	g["Number"] = native("Number", func(vm *VM, this Value, args []Value) (Value, error) {
+		if len(args) == 0 {
+			return 0.0, nil
+		}
+		return toNumber(args[0]), nil
+	})
+
+	g["Boolean"] = native("Boolean", func(vm *VM, this Value, args []Value) (Value, error) {
+		return toBool(argOr(args, 0)), nil
+	})
+
+	array := native("Array", arrayConstructor)
+	array.construct = func(vm *VM, args []Value) (Value, error) {
+		return arrayConstructor(vm, Undefined, args)
+	}
+	array.properties().set("isArray", native("isArray", func(vm *VM, this Value, args []Value) (Value, error) {
+		_, ok := argOr(args, 0).(*Array)
+		return ok, nil
+	}))
+	g["Array"] = array
+
+	object := native("Object", func(vm *VM, this Value, args []Value) (Value, error) {
+		v := argOr(args, 0)
+		if isObjectLike(v) {
+			return v, nil
+		}
+		return newObject(), nil
+	})
+	object.construct = func(vm *VM, args []Value) (Value, error) {
+		return object.native(vm, Undefined, args)
+	}
+	object.properties().set("keys", native("keys", func(vm *VM, this Value, args []Value) (Value, error) {
+		var keys []Value
+		for _, key := range ownKeys(argOr(args, 0)) {
+			keys = append(keys, key)
+		}
+		return newArray(keys), nil
+	}))
+	g["Object"] = object
+
+	g["Function"] = native("Function", func(vm *VM, this Value, args []Value) (Value, error) {
+		return nil, vm.throwf("EvalError", "code generation from strings is disabled")
+	})
+
+	mathObj := newObject()
+	mathObj.class = "Math"
+	for name, fn := range map[string]func(float64) float64{
+		"abs": math.Abs, "floor": math.Floor, "ceil": math.Ceil, "sqrt": math.Sqrt,
+		"trunc": math.Trunc, "log": math.Log, "exp": math.Exp, "sin": math.Sin, "cos": math.Cos,
+		"round": func(f float64) float64 { return math.Floor(f + 0.5) },
+		"sign": func(f float64) float64 {
+			if f > 0 {
+				return 1
+			} else if f < 0 {
+				return -1
+			}
+			return f
+		},
+	} {
+		fn := fn
+		mathObj.set(name, native(name, func(vm *VM, this Value, args []Value) (Value, error) {
+			return fn(toNumber(argOr(args, 0))), nil
+		}))
+	}
+	mathObj.set("pow", native("pow", func(vm *VM, this Value, args []Value) (Value, error) {
+		return math.Pow(toNumber(argOr(args, 0)), toNumber(argOr(args, 1))), nil
+	}))
+	mathObj.set("max", native("max", mathMinMax(true)))
+	mathObj.set("min", native("min", mathMinMax(false)))
+	mathObj.set("random", native("random", func(vm *VM, this Value, args []Value) (Value, error) {
+		return rand.Float64(), nil
+	}))
+	mathObj.set("PI", math.Pi)
+	g["Math"] = mathObj
+
+	g["parseInt"] = native("parseInt", globalParseInt)
+	g["parseFloat"] = native("parseFloat", globalParseFloat)
+	g["isNaN"] = native("isNaN", func(vm *VM, this Value, args []Value) (Value, error) {
+		return math.IsNaN(toNumber(argOr(args, 0))), nil
+	})
+	g["isFinite"] = native("isFinite", func(vm *VM, this Value, args []Value) (Value, error) {
+		f := toNumber(argOr(args, 0))
+		return !math.IsNaN(f) && !math.IsInf(f, 0), nil
+	})
+	g["encodeURIComponent"] = native("encodeURIComponent", func(vm *VM, this Value, args []Value) (Value, error) {
+		return encodeURIComponent(toString(argOr(args, 0))), nil
+	})
+	g["decodeURIComponent"] = native("decodeURIComponent", func(vm *VM, this Value, args []Value) (Value, error) {
+		s, err := url.PathUnescape(toString(argOr(args, 0)))
+		if err != nil {
+			return nil, vm.throwf("URIError", "URI malformed")
+		}
+		return s, nil
+	})
+
+	for _, name := range []string{"Error", "TypeError", "RangeError", "ReferenceError", "SyntaxError"} {
+		name := name
+		ctor := native(name, func(vm *VM, this Value, args []Value) (Value, error) {
+			message := ""
+			if len(args) > 0 && !isUndefined(args[0]) {
+				message = toString(args[0])
+			}
+			return newError(name, message), nil
+		})
+		ctor.construct = func(vm *VM, args []Value) (Value, error) {
+			return ctor.native(vm, Undefined, args)
+		}
+		g[name] = ctor
+	}
+
+	rx := native("RegExp", regexpConstructor)
+	rx.construct = func(vm *VM, args []Value) (Value, error) {
+		return regexpConstructor(vm, Undefined, args)
+	}
+	g["RegExp"] = rx
+
+	date := native("Date", func(vm *VM, this Value, args []Value) (Value, error) {
+		return time.Now().UTC().Format("Mon Jan 02 2006 15:04:05 GMT+0000"), nil
+	})
+	date.construct = dateConstructor
+	date.properties().set("now", native("now", func(vm *VM, this Value, args []Value) (Value, error) {
+		return float64(time.Now().UnixNano() / int64(time.Millisecond)), nil
+	}))
+	g["Date"] = date
+}
+
+func mathMinMax(max bool) nativeFunc {
+	return func(vm *VM, this Value, args []Value) (Value, error) {
+		result := math.Inf(1)
+		if max {
+			result = math.Inf(-1)
+		}
+		for _, arg := range args {
+			f := toNumber(arg)
+			if math.IsNaN(f) {
+				return f, nil
+			}
+			if max && f > result || !max && f < result {
+				result = f
+			}
+		}
+		return result, nil
+	}
+}
+
+func globalParseInt(vm *VM, this Value, args []Value) (Value, error) {
+	s := strings.TrimSpace(toString(argOr(args, 0)))
+	radix := int(toInteger(argOr(args, 1)))
+
+	sign := 1.0
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	if (radix == 0 || radix == 16) && (strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X")) {
+		s = s[2:]
+		radix = 16
+	}
+	if radix == 0 {
+		radix = 10
+	}
+	if radix < 2 || radix > 36 {
+		return math.NaN(), nil
+	}
+
+	// parse the longest valid prefix
+	end := 0
+	for end < len(s) {
+		d := digitValue(s[end])
+		if d < 0 || d >= radix {
+			break
+		}
+		end++
+	}
+	if end == 0 {
+		return math.NaN(), nil
+	}
+
+	result := 0.0
+	for i := 0; i < end; i++ {
+		result = result*float64(radix) + float64(digitValue(s[i]))
+	}
+	return sign * result, nil
+}
+
+func digitValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 10
+	}
+	return -1
+}
+
+func globalParseFloat(vm *VM, this Value, args []Value) (Value, error) {
+	s := strings.TrimSpace(toString(argOr(args, 0)))
+	for end := len(s); end > 0; end-- {
+		if f, err := strconv.ParseFloat(s[:end], 64); err == nil && !strings.ContainsAny(s[:end], "xXnN_") {
+			return f, nil
+		}
+	}
+	if strings.HasPrefix(s, "Infinity") || strings.HasPrefix(s, "+Infinity") {
+		return math.Inf(1), nil
+	}
+	if strings.HasPrefix(s, "-Infinity") {
+		return math.Inf(-1), nil
+	}
+	return math.NaN(), nil
+}
+
+func encodeURIComponent(s string) string {
+	const unreserved = "-_.!~*'()"
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte(unreserved, c) >= 0 {
+			sb.WriteByte(c)
+		} else {
+			sb.WriteString("%" + strings.ToUpper(strconv.FormatInt(int64(c)|0x100, 16)[1:]))
+		}
+	}
+	return sb.String()
+}
+
+// strings
+
+func stringValueOf(vm *VM, this Value, args []Value) (Value, error) {
+	return toString(this), nil
+}
+
+func stringSplit(vm *VM, this Value, args []Value) (Value, error) {
+	s := toString(this)
+	limit := -1
+	if l := argOr(args, 1); !isUndefined(l) {
+		limit = int(toUint32(l))
+	}
+
+	var parts []string
+	switch sep := argOr(args, 0).(type) {
+	case undefinedType:
+		parts = []string{s}
+	case *RegExp:
+		parts = sep.rx.Split(s, -1)
+	default:
+		sepStr := toString(sep)
+		if sepStr == "" {
+			// split into code units
+			if isASCII(s) {
+				parts = strings.Split(s, "")
+			} else {
+				for _, unit := range toUTF16(s) {
+					parts = append(parts, fromUTF16([]uint16{unit}))
+				}
+			}
+		} else {
+			parts = strings.Split(s, sepStr)
+		}
+	}
+
+	if limit >= 0 && limit < len(parts) {
+		parts = parts[:limit]
+	}
+
+	elems := make([]Value, len(parts))
+	for i, part := range parts {
+		elems[i] = part
+	}
+	return newArray(elems), nil
+}
+
+func stringCharAt(vm *VM, this Value, args []Value) (Value, error) {
+	s := toString(this)
+	i := int(toInteger(argOr(args, 0)))
+	if strCodeAt(s, i) < 0 {
+		return "", nil
+	}
+	return strSlice(s, i, i+1), nil
+}
+
+func stringCharCodeAt(vm *VM, this Value, args []Value) (Value, error) {
+	code := strCodeAt(toString(this), int(toInteger(argOr(args, 0))))
+	if code < 0 {
+		return math.NaN(), nil
+	}
+	return float64(code), nil
+}
+
+func stringIndexOf(vm *VM, this Value, args []Value) (Value, error) {
+	s := toString(this)
+	from := relativeIndex(argOr(args, 1), strLen(s))
+	if toInteger(argOr(args, 1)) < 0 {
+		from = 0
+	}
+	return float64(strIndex(s, toString(argOr(args, 0)), from)), nil
+}
+
+func stringLastIndexOf(vm *VM, this Value, args []Value) (Value, error) {
+	s, sub := toString(this), toString(argOr(args, 0))
+	last := -1
+	for i := strIndex(s, sub, 0); i >= 0; i = strIndex(s, sub, i+1) {
+		last = i
+		if i+1 > strLen(s) {
+			break
+		}
+	}
+	return float64(last), nil
+}
+
+func stringIncludes(vm *VM, this Value, args []Value) (Value, error) {
+	return strings.Contains(toString(this), toString(argOr(args, 0))), nil
+}
+
+func stringStartsWith(vm *VM, this Value, args []Value) (Value, error) {
+	return strings.HasPrefix(toString(this), toString(argOr(args, 0))), nil
+}
+
+func stringEndsWith(vm *VM, this Value, args []Value) (Value, error) {
+	return strings.HasSuffix(toString(this), toString(argOr(args, 0))), nil
+}
+
+func stringSlice(vm *VM, this Value, args []Value) (Value, error) {
+	s := toString(this)
+	length := strLen(s)
+	start := relativeIndex(argOr(args, 0), length)
+	end := length
+	if e := argOr(args, 1); !isUndefined(e) {
+		end = relativeIndex(e, length)
+	}
+	return strSlice(s, start, end), nil
+}
+
+func stringSubstring(vm *VM, this Value, args []Value) (Value, error) {
+	s := toString(this)
+	length := strLen(s)
+
+	clamp := func(v Value, def int) int {
+		if isUndefined(v) {
+			return def
+		}
+		f := toInteger(v)
+		if f < 0 {
+			return 0
+		}
+		if f > float64(length) {
+			return length
+		}
+		return int(f)
+	}
+
+	start, end := clamp(argOr(args, 0), 0), clamp(argOr(args, 1), length)
+	if start > end {
+		start, end = end, start
+	}
+	return strSlice(s, start, end), nil
+}
+
+func stringSubstr(vm *VM, this Value, args []Value) (Value, error) {
+	s := toString(this)
+	length := strLen(s)
+	start := relativeIndex(argOr(args, 0), length)
+	count := length - start
+	if c := argOr(args, 1); !isUndefined(c) {
+		count = int(math.Min(math.Max(toInteger(c), 0), float64(count)))
+	}
+	return strSlice(s, start, start+count), nil
+}
+
+func stringToUpper(vm *VM, this Value, args []Value) (Value, error) {
+	return strings.ToUpper(toString(this)), nil
+}
+
+func stringToLower(vm *VM, this Value, args []Value) (Value, error) {
+	return strings.ToLower(toString(this)), nil
+}
+
+func stringTrim(vm *VM, this Value, args []Value) (Value, error) {
+	return strings.TrimSpace(toString(this)), nil
+}
+
+func stringConcat(vm *VM, this Value, args []Value) (Value, error) {
+	var sb strings.Builder
+	sb.WriteString(toString(this))
+	for _, arg := range args {
+		sb.WriteString(toString(arg))
+	}
+	return sb.String(), nil
+}
+
+func stringRepeat(vm *VM, this Value, args []Value) (Value, error) {
+	s := toString(this)
+	count := toInteger(argOr(args, 0))
+	if count < 0 || float64(len(s))*count > maxLength {
+		return nil, vm.throwf("RangeError", "invalid count value")
+	}
+	return strings.Repeat(s, int(count)), nil
+}
+
+// expand $&, $1 and $$ in a replacement string
+func expandReplacement(repl string, match []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		if c != '$' || i+1 >= len(repl) {
+			sb.WriteByte(c)
+			continue
+		}
+
+		next := repl[i+1]
+		switch {
+		case next == '$':
+			sb.WriteByte('$')
+			i++
+		case next == '&':
+			sb.WriteString(match[0])
+			i++
+		case next >= '0' && next <= '9':
+			n := int(next - '0')
+			i++
+			if i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9' && n*10+int(repl[i+1]-'0') < len(match) {
+				n = n*10 + int(repl[i+1]-'0')
+				i++
+			}
+			if n > 0 && n < len(match) {
+				sb.WriteString(match[n])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func stringReplace(all bool) nativeFunc {
+	return func(vm *VM, this Value, args []Value) (Value, error) {
+		s := toString(this)
+		pattern, replacement := argOr(args, 0), argOr(args, 1)
+
+		// [start, end] pairs of each match and its groups, as byte offsets
+		var matches [][]int
+		if rx, ok := pattern.(*RegExp); ok {
+			if rx.global() {
+				matches = rx.rx.FindAllStringSubmatchIndex(s, -1)
+			} else if m := rx.rx.FindStringSubmatchIndex(s); m != nil {
+				matches = [][]int{m}
+			}
+		} else {
+			sub := toString(pattern)
+			for from := 0; from <= len(s); {
+				i := strings.Index(s[from:], sub)
+				if i < 0 {
+					break
+				}
+				matches = append(matches, []int{from + i, from + i + len(sub)})
+				if !all {
+					break
+				}
+				from += i + len(sub)
+				if sub == "" {
+					from++
+				}
+			}
+		}
+
+		var sb strings.Builder
+		last := 0
+		for _, m := range matches {
+			groups := make([]string, len(m)/2)
+			for g := range groups {
+				if m[2*g] >= 0 {
+					groups[g] = s[m[2*g]:m[2*g+1]]
+				}
+			}
+
+			var repl string
+			if fn, ok := replacement.(*Function); ok {
+				callArgs := []Value{}
+				for _, group := range groups {
+					callArgs = append(callArgs, group)
+				}
+				callArgs = append(callArgs, float64(strLen(s[:m[0]])), s)
+				v, err := vm.call(fn, Undefined, callArgs)
+				if err != nil {
+					return nil, err
+				}
+				repl = toString(v)
+			} else {
+				repl = expandReplacement(toString(replacement), groups)
+			}
+
+			sb.WriteString(s[last:m[0]])
+			sb.WriteString(repl)
+			last = m[1]
+		}
+		sb.WriteString(s[last:])
+		return sb.String(), nil
+	}
+}
+
+func stringMatch(vm *VM, this Value, args []Value) (Value, error) {
+	s := toString(this)
+	rx, ok := argOr(args, 0).(*RegExp)
+	if !ok {
+		var err error
+		if rx, err = compileRegExp(toString(argOr(args, 0)), ""); err != nil {
+			return nil, vm.throwf("SyntaxError", "invalid regular expression")
+		}
+	}
+
+	if rx.global() {
+		found := rx.rx.FindAllString(s, -1)
+		if found == nil {
+			return Null, nil
+		}
+		elems := make([]Value, len(found))
+		for i, m := range found {
+			elems[i] = m
+		}
+		return newArray(elems), nil
+	}
+	return regexpExec(vm, rx, []Value{s})
+}
+
+// arrays
+
+func thisArray(vm *VM, this Value) (*Array, error) {
+	arr, ok := this.(*Array)
+	if !ok {
+		return nil, vm.throwf("TypeError", "array method called on %s", typeOf(this))
+	}
+	return arr, nil
+}
+
+func arrayConstructor(vm *VM, this Value, args []Value) (Value, error) {
+	if len(args) == 1 {
+		if n, ok := args[0].(float64); ok {
+			if n < 0 || !isIntegral(n) || n > maxLength {
+				return nil, vm.throwf("RangeError", "invalid array length")
+			}
+			elems := make([]Value, int(n))
+			for i := range elems {
+				elems[i] = Undefined
+			}
+			return newArray(elems), nil
+		}
+	}
+	return newArray(append([]Value{}, args...)), nil
+}
+
+func arrayPush(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	arr.elems = append(arr.elems, args...)
+	return float64(len(arr.elems)), nil
+}
+
+func arrayPop(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	if len(arr.elems) == 0 {
+		return Undefined, nil
+	}
+	v := arr.elems[len(arr.elems)-1]
+	arr.elems = arr.elems[:len(arr.elems)-1]
+	return v, nil
+}
+
+func arrayShift(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	if len(arr.elems) == 0 {
+		return Undefined, nil
+	}
+	v := arr.elems[0]
+	arr.elems = append([]Value{}, arr.elems[1:]...)
+	return v, nil
+}
+
+func arrayUnshift(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	arr.elems = append(append([]Value{}, args...), arr.elems...)
+	return float64(len(arr.elems)), nil
+}
+
+func arraySplice(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+
+	length := len(arr.elems)
+	start := relativeIndex(argOr(args, 0), length)
+	count := length - start
+	if len(args) == 0 {
+		count = 0
+	} else if len(args) > 1 {
+		count = int(math.Min(math.Max(toInteger(args[1]), 0), float64(length-start)))
+	}
+
+	removed := append([]Value{}, arr.elems[start:start+count]...)
+
+	var items []Value
+	if len(args) > 2 {
+		items = args[2:]
+	}
+	rest := append(append([]Value{}, items...), arr.elems[start+count:]...)
+	arr.elems = append(arr.elems[:start], rest...)
+
+	return newArray(removed), nil
+}
+
+func arrayReverse(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(arr.elems)-1; i < j; i, j = i+1, j-1 {
+		arr.elems[i], arr.elems[j] = arr.elems[j], arr.elems[i]
+	}
+	return arr, nil
+}
+
+func arraySlice(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	length := len(arr.elems)
+	start := relativeIndex(argOr(args, 0), length)
+	end := length
+	if e := argOr(args, 1); !isUndefined(e) {
+		end = relativeIndex(e, length)
+	}
+	if start > end {
+		start = end
+	}
+	return newArray(append([]Value{}, arr.elems[start:end]...)), nil
+}
+
+func arrayJoin(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	sep := ","
+	if s := argOr(args, 0); !isUndefined(s) {
+		sep = toString(s)
+	}
+
+	joined, err := joinArray(arr, sep, nil)
+	if err != nil {
+		return nil, vm.throwf("RangeError", "%v", err)
+	}
+	return joined, nil
+}
+
+func arrayToString(vm *VM, this Value, args []Value) (Value, error) {
+	return arrayJoin(vm, this, nil)
+}
+
+func arrayIndexOf(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	target := argOr(args, 0)
+	for i := relativeIndex(argOr(args, 1), len(arr.elems)); i < len(arr.elems); i++ {
+		if strictEquals(arr.elems[i], target) {
+			return float64(i), nil
+		}
+	}
+	return -1.0, nil
+}
+
+func arrayLastIndexOf(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	target := argOr(args, 0)
+
+	// a negative fromIndex counts back from the end, only a missing one
+	// means the whole array
+	from := float64(len(arr.elems) - 1)
+	if len(args) > 1 {
+		if f := toInteger(args[1]); f < 0 {
+			from = f + float64(len(arr.elems))
+		} else if f < from {
+			from = f
+		}
+	}
+
+	for i := int(from); i >= 0; i-- {
+		if strictEquals(arr.elems[i], target) {
+			return float64(i), nil
+		}
+	}
+	return -1.0, nil
+}
+
+func arrayIncludes(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	target := argOr(args, 0)
+	for _, elem := range arr.elems {
+		if strictEquals(elem, target) {
+			return true, nil
+		}
+		if f, ok := target.(float64); ok && math.IsNaN(f) {
+			if e, ok := elem.(float64); ok && math.IsNaN(e) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func arrayConcat(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	elems := append([]Value{}, arr.elems...)
+	for _, arg := range args {
+		if other, ok := arg.(*Array); ok {
+			elems = append(elems, other.elems...)
+		} else {
+			elems = append(elems, arg)
+		}
+	}
+	return newArray(elems), nil
+}
+
+func arrayIterate(kind string) nativeFunc {
+	return func(vm *VM, this Value, args []Value) (Value, error) {
+		arr, err := thisArray(vm, this)
+		if err != nil {
+			return nil, err
+		}
+		fn := argOr(args, 0)
+		if _, ok := fn.(*Function); !ok {
+			return nil, vm.throwf("TypeError", "%s is not a function", toString(fn))
+		}
+		thisArg := argOr(args, 1)
+
+		var results []Value
+		for i := 0; i < len(arr.elems); i++ {
+			elem := arr.elems[i]
+			v, err := vm.call(fn, thisArg, []Value{elem, float64(i), arr})
+			if err != nil {
+				return nil, err
+			}
+
+			switch kind {
+			case "map":
+				results = append(results, v)
+			case "filter":
+				if toBool(v) {
+					results = append(results, elem)
+				}
+			case "some":
+				if toBool(v) {
+					return true, nil
+				}
+			case "every":
+				if !toBool(v) {
+					return false, nil
+				}
+			case "find":
+				if toBool(v) {
+					return elem, nil
+				}
+			case "findIndex":
+				if toBool(v) {
+					return float64(i), nil
+				}
+			}
+		}
+
+		switch kind {
+		case "map", "filter":
+			if results == nil {
+				results = []Value{}
+			}
+			return newArray(results), nil
+		case "some":
+			return false, nil
+		case "every":
+			return true, nil
+		case "findIndex":
+			return -1.0, nil
+		}
+		return Undefined, nil
+	}
+}
+
+func arrayReduce(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	fn := argOr(args, 0)
+
+	i := 0
+	var acc Value
+	if len(args) > 1 {
+		acc = args[1]
+	} else {
+		if len(arr.elems) == 0 {
+			return nil, vm.throwf("TypeError", "reduce of empty array with no initial value")
+		}
+		acc = arr.elems[0]
+		i = 1
+	}
+
+	for ; i < len(arr.elems); i++ {
+		if acc, err = vm.call(fn, Undefined, []Value{acc, arr.elems[i], float64(i), arr}); err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
+
+func arraySort(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+
+	cmp, ok := argOr(args, 0).(*Function)
+	if !ok {
+		sortByString(arr.elems)
+		return arr, nil
+	}
+
+	// insertion sort so comparator errors can be reported
+	elems := arr.elems
+	for i := 1; i < len(elems); i++ {
+		for j := i; j > 0; j-- {
+			v, err := vm.call(cmp, Undefined, []Value{elems[j-1], elems[j]})
+			if err != nil {
+				return nil, err
+			}
+			if toNumber(v) <= 0 {
+				break
+			}
+			elems[j-1], elems[j] = elems[j], elems[j-1]
+		}
+	}
+	return arr, nil
+}
+
+func arrayFill(vm *VM, this Value, args []Value) (Value, error) {
+	arr, err := thisArray(vm, this)
+	if err != nil {
+		return nil, err
+	}
+	length := len(arr.elems)
+	start := relativeIndex(argOr(args, 1), length)
+	end := length
+	if e := argOr(args, 2); !isUndefined(e) {
+		end = relativeIndex(e, length)
+	}
+	for i := start; i < end; i++ {
+		arr.elems[i] = argOr(args, 0)
+	}
+	return arr, nil
+}
+
+// objects and functions
+
+func objectHasOwnProperty(vm *VM, this Value, args []Value) (Value, error) {
+	key := argOr(args, 0)
+	switch o := this.(type) {
+	case *Object:
+		_, ok := o.get(toPropertyKey(key))
+		return ok, nil
+	case *Array:
+		if i := arrayIndex(key); i >= 0 {
+			return i < len(o.elems), nil
+		}
+		if o.props != nil {
+			_, ok := o.props.get(toPropertyKey(key))
+			return ok, nil
+		}
+	}
+	return false, nil
+}
+
+func objectToString(vm *VM, this Value, args []Value) (Value, error) {
+	switch this.(type) {
+	case *Object, *Array, *Function, *RegExp:
+		return toString(this), nil
+	}
+	return toString(this), nil
+}
+
+func objectValueOf(vm *VM, this Value, args []Value) (Value, error) {
+	return this, nil
+}
+
+func functionCall(vm *VM, this Value, args []Value) (Value, error) {
+	var rest []Value
+	if len(args) > 1 {
+		rest = args[1:]
+	}
+	return vm.call(this, argOr(args, 0), rest)
+}
+
+func functionApply(vm *VM, this Value, args []Value) (Value, error) {
+	var callArgs []Value
+	switch list := argOr(args, 1).(type) {
+	case *Array:
+		callArgs = append(callArgs, list.elems...)
+	case undefinedType, nullType:
+	default:
+		return nil, vm.throwf("TypeError", "apply arguments must be an array")
+	}
+	return vm.call(this, argOr(args, 0), callArgs)
+}
+
+func functionBind(vm *VM, this Value, args []Value) (Value, error) {
+	target, ok := this.(*Function)
+	if !ok {
+		return nil, vm.throwf("TypeError", "bind called on a non function")
+	}
+	boundThis := argOr(args, 0)
+	var bound []Value
+	if len(args) > 1 {
+		bound = append(bound, args[1:]...)
+	}
+
+	return native("bound "+target.name, func(vm *VM, _ Value, callArgs []Value) (Value, error) {
+		return vm.call(target, boundThis, append(append([]Value{}, bound...), callArgs...))
+	}), nil
+}
+
+// numbers
+
+func numberToStringMethod(vm *VM, this Value, args []Value) (Value, error) {
+	radix := 10
+	if r := argOr(args, 0); !isUndefined(r) {
+		radix = int(toInteger(r))
+		if radix < 2 || radix > 36 {
+			return nil, vm.throwf("RangeError", "toString() radix must be between 2 and 36")
+		}
+	}
+	return numberToRadix(toNumber(this), radix), nil
+}
+
+func numberToFixed(vm *VM, this Value, args []Value) (Value, error) {
+	digits := int(toInteger(argOr(args, 0)))
+	if digits < 0 || digits > 100 {
+		return nil, vm.throwf("RangeError", "toFixed() digits argument must be between 0 and 100")
+	}
+	return strconv.FormatFloat(toNumber(this), 'f', digits, 64), nil
+}
+
+// regular expressions
+
+func regexpConstructor(vm *VM, this Value, args []Value) (Value, error) {
+	source := argOr(args, 0)
+	if rx, ok := source.(*RegExp); ok {
+		source = rx.source
+	}
+	flags := ""
+	if f := argOr(args, 1); !isUndefined(f) {
+		flags = toString(f)
+	}
+
+	rx, err := compileRegExp(toString(source), flags)
+	if err != nil {
+		return nil, vm.throwf("SyntaxError", "invalid regular expression: %v", err)
+	}
+	return rx, nil
+}
+
+func regexpTest(vm *VM, this Value, args []Value) (Value, error) {
+	v, err := regexpExec(vm, this, args)
+	if err != nil {
+		return nil, err
+	}
+	_, isNull := v.(nullType)
+	return !isNull, nil
+}
+
+func regexpExec(vm *VM, this Value, args []Value) (Value, error) {
+	rx, ok := this.(*RegExp)
+	if !ok {
+		return nil, vm.throwf("TypeError", "exec called on a non regexp")
+	}
+	s := toString(argOr(args, 0))
+
+	// lastIndex is only honoured by global regexps, counted in bytes here
+	from := 0
+	if rx.global() {
+		from = rx.lastIndex
+		if from > len(s) {
+			rx.lastIndex = 0
+			return Null, nil
+		}
+	}
+
+	m := rx.rx.FindStringSubmatchIndex(s[from:])
+	if m == nil {
+		rx.lastIndex = 0
+		return Null, nil
+	}
+
+	elems := make([]Value, len(m)/2)
+	for g := range elems {
+		if m[2*g] < 0 {
+			elems[g] = Undefined
+		} else {
+			elems[g] = s[from+m[2*g] : from+m[2*g+1]]
+		}
+	}
+	if rx.global() {
+		rx.lastIndex = from + m[1]
+	}
+
+	arr := newArray(elems)
+	arr.props = newObject()
+	arr.props.set("index", float64(strLen(s[:from+m[0]])))
+	arr.props.set("input", s)
+	return arr, nil
+}
+
+// dates, only enough to turn a date string into a timestamp
+
+func dateConstructor(vm *VM, args []Value) (Value, error) {
+	var ms float64
+	switch {
+	case len(args) == 0:
+		ms = float64(time.Now().UnixNano() / int64(time.Millisecond))
+	case len(args) == 1:
+		if s, ok := args[0].(string); ok {
+			ms = math.NaN()
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z07:00", "2006-01-02", time.RFC1123} {
+				if t, err := time.Parse(layout, s); err == nil {
+					ms = float64(t.UnixNano() / int64(time.Millisecond))
+					break
+				}
+			}
+		} else {
+			ms = toNumber(args[0])
+		}
+	default:
+		// year, month and so on in UTC, local time isn't modelled
+		parts := [7]int{0, 0, 1, 0, 0, 0, 0}
+		for i := 0; i < len(args) && i < 7; i++ {
+			parts[i] = int(toInteger(args[i]))
+		}
+		t := time.Date(parts[0], time.Month(parts[1]+1), parts[2], parts[3], parts[4], parts[5], parts[6]*int(time.Millisecond), time.UTC)
+		ms = float64(t.UnixNano() / int64(time.Millisecond))
+	}
+
+	obj := newObject()
+	obj.class = "Date"
+	obj.props["__time__"] = ms
+	return obj, nil
+}
+
+func dateGetTime(vm *VM, this Value, args []Value) (Value, error) {
+	obj, ok := this.(*Object)
+	if !ok || obj.class != "Date" {
+		return nil, vm.throwf("TypeError", "this is not a Date object")
+	}
+	return obj.props["__time__"], nil
+}
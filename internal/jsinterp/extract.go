@@ -0,0 +1,420 @@
+package jsinterp
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Definition finds where name is declared as a function or first assigned in
+// src and returns it as a standalone statement. Only the definition itself is
+// tokenized, so the rest of src may use syntax the parser doesn't support.
+func Definition(src string, name string) (string, error) {
+	q := regexp.QuoteMeta(name)
+
+	rxFunc := regexp.MustCompile(`(?:^|[^\w$.])function\s+` + q + `\s*\(`)
+	if loc := rxFunc.FindStringIndex(src); loc != nil {
+		start := strings.Index(src[loc[0]:], "function") + loc[0]
+		end, err := scanFunctionEnd(src, start)
+		if err != nil {
+			return "", err
+		}
+		return src[start:end], nil
+	}
+
+	// name = value, but not name == value
+	rxAssign := regexp.MustCompile(`(?:^|[^\w$.])` + q + `\s*=([^=>]|$)`)
+	loc := rxAssign.FindStringSubmatchIndex(src)
+	if loc == nil {
+		return "", fmt.Errorf("couldn't find definition of %s", name)
+	}
+
+	valueStart := loc[2]
+	end, err := scanExpressionEnd(src, valueStart)
+	if err != nil {
+		return "", fmt.Errorf("couldn't scan definition of %s: %w", name, err)
+	}
+	return "var " + name + "=" + strings.TrimSpace(src[valueStart:end]) + ";", nil
+}
+
+// lexer positioned within a larger source, used for scanning
+func newScanner(src string, start int) *lexer {
+	return &lexer{src: src, pos: start}
+}
+
+// end offset of the function starting at start with the "function" keyword
+func scanFunctionEnd(src string, start int) (int, error) {
+	lx := newScanner(src, start)
+	depth := 0
+	seenBody := false
+
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			return 0, err
+		}
+		lx.tokens = append(lx.tokens[:0], tok)
+
+		switch {
+		case tok.kind == tokEOF:
+			return 0, &SyntaxError{start, "unterminated function"}
+		case tok.kind != tokPunct:
+		case tok.value == "{":
+			depth++
+			seenBody = true
+		case tok.value == "}":
+			depth--
+			if seenBody && depth == 0 {
+				return tok.end, nil
+			}
+		}
+	}
+}
+
+// end offset of the assignment expression starting at start, i.e. the first
+// ',' ';' or unbalanced closing bracket at the top level
+func scanExpressionEnd(src string, start int) (int, error) {
+	lx := newScanner(src, start)
+	depth := 0
+	count := 0
+
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			return 0, err
+		}
+		lx.tokens = append(lx.tokens[:0], tok)
+
+		if tok.kind == tokEOF {
+			return tok.pos, nil
+		}
+		if tok.kind == tokPunct {
+			switch tok.value {
+			case "(", "[", "{":
+				depth++
+			case ")", "]", "}":
+				if depth == 0 {
+					return tok.pos, nil
+				}
+				depth--
+				// a function body or object closing at the top level, where
+				// a line break ends the statement
+				if depth == 0 {
+					next, err := newScanner(src, tok.end).next()
+					if err == nil && next.newline && !(next.kind == tokPunct && strings.Contains(".,([?:+-*/%&|^=<>", next.value[:1])) {
+						return tok.end, nil
+					}
+				}
+			case ",", ";":
+				if depth == 0 && count > 0 {
+					return tok.pos, nil
+				}
+			}
+		}
+		count++
+	}
+}
+
+// Extract returns a program defining the function or variable name from src
+// together with every global it refers to, so that it can be run on its own.
+func Extract(src string, name string) (string, error) {
+	builtins := New().global.vars
+
+	var defs []string
+	seen := map[string]bool{name: true}
+	queue := []string{name}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		def, err := Definition(src, current)
+		if err != nil {
+			return "", err
+		}
+		defs = append(defs, def)
+
+		free, err := FreeVariables(def)
+		if err != nil {
+			return "", fmt.Errorf("couldn't parse definition of %s: %w", current, err)
+		}
+		for _, dep := range free {
+			if _, ok := builtins[dep]; ok || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			queue = append(queue, dep)
+		}
+	}
+
+	// dependencies first
+	for i, j := 0, len(defs)-1; i < j; i, j = i+1, j-1 {
+		defs[i], defs[j] = defs[j], defs[i]
+	}
+	return strings.Join(defs, "\n"), nil
+}
+
+// FreeVariables lists the names src refers to without declaring them.
+func FreeVariables(src string) ([]string, error) {
+	program, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	fv := &freeVars{found: map[string]bool{}}
+	fv.function(program, nil)
+
+	// names declared at the top level of src aren't free either
+	declared := fv.declarations(program)
+	var names []string
+	for name := range fv.found {
+		if !declared[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+type freeVars struct {
+	found map[string]bool
+}
+
+type declScope struct {
+	names  map[string]bool
+	parent *declScope
+}
+
+func (s *declScope) has(name string) bool {
+	for sc := s; sc != nil; sc = sc.parent {
+		if sc.names[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// every name declared in a function body, ignoring nested functions
+func (fv *freeVars) declarations(fn *funcLit) map[string]bool {
+	names := map[string]bool{}
+	if fn.name != "" {
+		names[fn.name] = true
+	}
+	for _, prm := range fn.params {
+		names[prm.name] = true
+	}
+	for _, name := range fn.vars {
+		names[name] = true
+	}
+	for _, decl := range fn.funcs {
+		names[decl.name] = true
+	}
+
+	var visit func(s stmt)
+	visit = func(s stmt) {
+		switch s := s.(type) {
+		case *varDecl:
+			for _, name := range s.names {
+				names[name] = true
+			}
+		case *blockStmt:
+			for _, b := range s.body {
+				visit(b)
+			}
+		case *ifStmt:
+			visit(s.cons)
+			if s.alt != nil {
+				visit(s.alt)
+			}
+		case *forStmt:
+			if s.init != nil {
+				visit(s.init)
+			}
+			visit(s.body)
+		case *forInStmt:
+			if id, ok := s.target.(*ident); ok && s.decl != "" {
+				names[id.name] = true
+			}
+			visit(s.body)
+		case *whileStmt:
+			visit(s.body)
+		case *doWhileStmt:
+			visit(s.body)
+		case *labeledStmt:
+			visit(s.body)
+		case *tryStmt:
+			visit(s.block)
+			if s.param != "" {
+				names[s.param] = true
+			}
+			if s.handler != nil {
+				visit(s.handler)
+			}
+			if s.finalizer != nil {
+				visit(s.finalizer)
+			}
+		case *switchStmt:
+			for _, c := range s.cases {
+				for _, b := range c.body {
+					visit(b)
+				}
+			}
+		}
+	}
+	for _, s := range fn.body {
+		visit(s)
+	}
+	return names
+}
+
+func (fv *freeVars) function(fn *funcLit, parent *declScope) {
+	sc := &declScope{names: fv.declarations(fn), parent: parent}
+	if !fn.arrow {
+		sc.names["arguments"] = true
+	}
+
+	for _, prm := range fn.params {
+		if prm.defaultVal != nil {
+			fv.expr(prm.defaultVal, sc)
+		}
+	}
+	if fn.exprBody != nil {
+		fv.expr(fn.exprBody, sc)
+	}
+	for _, s := range fn.body {
+		fv.stmt(s, sc)
+	}
+}
+
+func (fv *freeVars) stmt(s stmt, sc *declScope) {
+	switch s := s.(type) {
+	case *exprStmt:
+		fv.expr(s.x, sc)
+	case *varDecl:
+		for _, init := range s.inits {
+			if init != nil {
+				fv.expr(init, sc)
+			}
+		}
+	case *funcDecl:
+		fv.function(s.fn, sc)
+	case *blockStmt:
+		for _, b := range s.body {
+			fv.stmt(b, sc)
+		}
+	case *ifStmt:
+		fv.expr(s.test, sc)
+		fv.stmt(s.cons, sc)
+		if s.alt != nil {
+			fv.stmt(s.alt, sc)
+		}
+	case *forStmt:
+		if s.init != nil {
+			fv.stmt(s.init, sc)
+		}
+		if s.test != nil {
+			fv.expr(s.test, sc)
+		}
+		if s.update != nil {
+			fv.expr(s.update, sc)
+		}
+		fv.stmt(s.body, sc)
+	case *forInStmt:
+		fv.expr(s.target, sc)
+		fv.expr(s.obj, sc)
+		fv.stmt(s.body, sc)
+	case *whileStmt:
+		fv.expr(s.test, sc)
+		fv.stmt(s.body, sc)
+	case *doWhileStmt:
+		fv.stmt(s.body, sc)
+		fv.expr(s.test, sc)
+	case *returnStmt:
+		if s.x != nil {
+			fv.expr(s.x, sc)
+		}
+	case *throwStmt:
+		fv.expr(s.x, sc)
+	case *tryStmt:
+		fv.stmt(s.block, sc)
+		if s.handler != nil {
+			fv.stmt(s.handler, sc)
+		}
+		if s.finalizer != nil {
+			fv.stmt(s.finalizer, sc)
+		}
+	case *switchStmt:
+		fv.expr(s.disc, sc)
+		for _, c := range s.cases {
+			if c.test != nil {
+				fv.expr(c.test, sc)
+			}
+			for _, b := range c.body {
+				fv.stmt(b, sc)
+			}
+		}
+	case *labeledStmt:
+		fv.stmt(s.body, sc)
+	}
+}
+
+func (fv *freeVars) exprs(list []expr, sc *declScope) {
+	for _, x := range list {
+		if x != nil {
+			fv.expr(x, sc)
+		}
+	}
+}
+
+func (fv *freeVars) expr(x expr, sc *declScope) {
+	switch x := x.(type) {
+	case *ident:
+		if !sc.has(x.name) && x.name != "undefined" {
+			fv.found[x.name] = true
+		}
+	case *arrayLit:
+		fv.exprs(x.elems, sc)
+	case *objectLit:
+		for _, prop := range x.props {
+			if prop.computed != nil {
+				fv.expr(prop.computed, sc)
+			}
+			fv.expr(prop.value, sc)
+		}
+	case *funcLit:
+		fv.function(x, sc)
+	case *unaryExpr:
+		fv.expr(x.x, sc)
+	case *updateExpr:
+		fv.expr(x.x, sc)
+	case *binaryExpr:
+		fv.expr(x.x, sc)
+		fv.expr(x.y, sc)
+	case *logicalExpr:
+		fv.expr(x.x, sc)
+		fv.expr(x.y, sc)
+	case *assignExpr:
+		fv.expr(x.target, sc)
+		fv.expr(x.value, sc)
+	case *condExpr:
+		fv.expr(x.test, sc)
+		fv.expr(x.cons, sc)
+		fv.expr(x.alt, sc)
+	case *callExpr:
+		fv.expr(x.callee, sc)
+		fv.exprs(x.args, sc)
+	case *newExpr:
+		fv.expr(x.callee, sc)
+		fv.exprs(x.args, sc)
+	case *memberExpr:
+		fv.expr(x.obj, sc)
+		if x.computed != nil {
+			fv.expr(x.computed, sc)
+		}
+	case *seqExpr:
+		fv.exprs(x.list, sc)
+	case *spreadExpr:
+		fv.expr(x.x, sc)
+	}
+}
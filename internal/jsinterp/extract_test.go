@@ -0,0 +1,130 @@
+package jsinterp
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// trimmed down from a player: a helper object of array transforms, a
+// signature function using it, an n transform dispatching through an array,
+// and code around them the parser doesn't support
+const testPlayer = `(function(g){class Ui{static #id=1;get v(){return this.#id}}
+var Xy={ab:function(a,b){a.splice(0,b)},cd:function(a){a.reverse()},ef:function(a,b){var c=a[0];a[0]=a[b%a.length];a[b%a.length]=c}};
+var sig=function(a){a=a.split("");Xy.cd(a,3);Xy.ab(a,2);Xy.ef(a,5);Xy.ab(a,1);return a.join("")};
+var nt=function(a){var b=a.split(""),c=[function(d,e){e=(e%d.length+d.length)%d.length;d.splice(-e).reverse().forEach(function(f){d.unshift(f)})},
+-1234,function(d){d.reverse()},function(d,e){e=(e%d.length+d.length)%d.length;var f=d[0];d[0]=d[e];d[e]=f},b,"abc",
+function(d,e){for(var f=64,h=[];++f-h.length-32;){switch(f){case 58:f=96;continue;case 91:f=44;break;case 65:f=47;continue;case 46:f=153;case 123:f-=58;default:h.push(String.fromCharCode(f))}}d.forEach(function(l,m,n){this.push(n[m]=h[(h.indexOf(l)-h.indexOf(this[m])+m-32+f--)%h.length])},e.split(""))}];
+try{c[0](c[4],c[1]);c[2](c[4]);c[3](c[4],7);c[6](c[4],c[5]);c[0](c[4],3)}catch(d){return"enhanced_except_"+a}return b.join("")};
+g.load=async()=>{await fetch(` + "`/api/${g.id}`" + `)};
+})(_yt_player);`
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		// outputs checked against node
+		{"sig", "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", "6543710ZYXWVUTSRQPONMLKJIHGFEDCBA"},
+		{"nt", "kH3xQ9bZpL2mNw", "Bzof9kydKduf-H"},
+	}
+
+	for _, test := range tests {
+		program, err := Extract(testPlayer, test.name)
+		if err != nil {
+			t.Errorf("Extract(%s): %v", test.name, err)
+			continue
+		}
+
+		vm := New()
+		if _, err := vm.Run(context.Background(), program); err != nil {
+			t.Errorf("Run(%s): %v\n%s", test.name, err, program)
+			continue
+		}
+
+		got, err := vm.Call(context.Background(), test.name, test.arg)
+		if err != nil {
+			t.Errorf("Call(%s): %v", test.name, err)
+			continue
+		}
+		if ToString(got) != test.want {
+			t.Errorf("%s(%q) = %q, want %q", test.name, test.arg, ToString(got), test.want)
+		}
+	}
+}
+
+func TestExtractDependencyOrder(t *testing.T) {
+	program, err := Extract(testPlayer, "sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper := strings.Index(program, "Xy=")
+	fn := strings.Index(program, "sig=")
+	if helper == -1 || fn == -1 || helper > fn {
+		t.Errorf("helper should be defined before sig:\n%s", program)
+	}
+	if strings.Contains(program, "class") || strings.Contains(program, "nt=") {
+		t.Errorf("unrelated code was extracted:\n%s", program)
+	}
+}
+
+func TestExtractMissing(t *testing.T) {
+	if _, err := Extract(testPlayer, "missing"); err == nil {
+		t.Error("extracting an undefined name didn't fail")
+	}
+
+	// sig's helper is gone
+	if _, err := Extract(`var sig=function(a){return Zz.f(a)};`, "sig"); err == nil {
+		t.Error("extracting with an undefined dependency didn't fail")
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		src  string
+		name string
+		want string
+	}{
+		{`x();function f(a){return {b:a}}g();`, "f", `function f(a){return {b:a}}`},
+		{`var a=1,f=function(b){return b==1},c=2;`, "f", `var f=function(b){return b==1}`},
+		{`if(f==2)h();var f=[1,"]",function(){}];`, "f", `var f=[1,"]",function(){}]`},
+		{`a.f=1;var f=3;`, "f", `var f=3`},
+	}
+
+	for _, test := range tests {
+		got, err := Definition(test.src, test.name)
+		if err != nil {
+			t.Errorf("Definition(%q, %s): %v", test.src, test.name, err)
+			continue
+		}
+		if strings.TrimSuffix(strings.TrimSpace(got), ";") != test.want {
+			t.Errorf("Definition(%q, %s) = %q, want %q", test.src, test.name, got, test.want)
+		}
+	}
+}
+
+func TestFreeVariables(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{`var f=function(a){var b=a.split("");Xy.cd(b);return b.join(g)}`, []string{"Xy", "g"}},
+		{`function f(a){try{h(a)}catch(e){return e}return function(){return a+k}}`, []string{"h", "k"}},
+		{`var f=function(a){for(var i in a)q[i]=a[i];return typeof z}`, []string{"q", "z"}},
+	}
+
+	for _, test := range tests {
+		got, err := FreeVariables(test.src)
+		if err != nil {
+			t.Errorf("FreeVariables(%q): %v", test.src, err)
+			continue
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("FreeVariables(%q) = %q, want %q", test.src, got, test.want)
+		}
+	}
+}
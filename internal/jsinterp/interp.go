@@ -0,0 +1,1481 @@
+// Package jsinterp is a small JavaScript interpreter used to run the cipher
+// functions found in YouTube's player. It implements the ES5 subset those
+// functions are written in plus a few later additions, has no access to the
+// host beyond a handful of builtins, and bounds every run by a step budget,
+// a call depth limit and a context.
+package jsinterp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Default limits, generous for cipher functions which run a few thousand steps.
+const (
+	DefaultMaxSteps = 10000000
+	DefaultMaxDepth = 256
+)
+
+// longest string or array a script may build, so it can't exhaust memory
+// within its step budget
+const maxLength = 1 << 24
+
+var (
+	// ErrStepLimit is returned when a run exceeds its step budget.
+	ErrStepLimit = errors.New("step limit exceeded")
+
+	// ErrDepthLimit is returned when calls nest deeper than allowed.
+	ErrDepthLimit = errors.New("call depth limit exceeded")
+)
+
+// ThrowError is a JavaScript exception that wasn't caught.
+type ThrowError struct {
+	Value Value
+}
+
+func (e *ThrowError) Error() string {
+	return "uncaught exception: " + toString(e.Value)
+}
+
+// VM holds the global scope of a program. It is not safe for concurrent use.
+type VM struct {
+	// limits applied to each Run or Call
+	MaxSteps int
+	MaxDepth int
+
+	global *scope
+	ctx    context.Context
+	steps  int
+	depth  int
+}
+
+// New returns a VM with the builtins installed and the default limits.
+func New() *VM {
+	vm := &VM{
+		MaxSteps: DefaultMaxSteps,
+		MaxDepth: DefaultMaxDepth,
+		global:   newScope(nil, true),
+	}
+	vm.global.this = Undefined
+	installBuiltins(vm)
+	return vm
+}
+
+// Run executes src in the global scope, returning the value of the last
+// expression statement.
+func (vm *VM) Run(ctx context.Context, src string) (Value, error) {
+	program, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.begin(ctx)
+	vm.hoist(program, vm.global)
+
+	var last Value = Undefined
+	for _, s := range program.body {
+		if es, ok := s.(*exprStmt); ok {
+			v, err := vm.eval(es.x, vm.global)
+			if err != nil {
+				return nil, err
+			}
+			last = v
+			continue
+		}
+
+		c, err := vm.exec(s, vm.global)
+		if err != nil {
+			return nil, err
+		}
+		if c.kind == completionReturn {
+			return c.value, nil
+		}
+	}
+	return last, nil
+}
+
+// Get returns a global variable.
+func (vm *VM) Get(name string) (Value, bool) {
+	v, ok := vm.global.vars[name]
+	return v, ok
+}
+
+// Set assigns a global variable.
+func (vm *VM) Set(name string, v Value) {
+	vm.global.vars[name] = v
+}
+
+// Call calls the global function name with string or number arguments.
+func (vm *VM) Call(ctx context.Context, name string, args ...Value) (Value, error) {
+	fn, ok := vm.global.vars[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is not defined", name)
+	}
+
+	vm.begin(ctx)
+	return vm.call(fn, Undefined, args)
+}
+
+func (vm *VM) begin(ctx context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	vm.ctx = ctx
+	vm.steps = 0
+	vm.depth = 0
+}
+
+// count a unit of work, checking the budget and the context
+func (vm *VM) step() error {
+	vm.steps++
+	if vm.MaxSteps > 0 && vm.steps > vm.MaxSteps {
+		return ErrStepLimit
+	}
+	if vm.steps&0xfff == 0 {
+		if err := vm.ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ToString converts a value the way String(v) does.
+func ToString(v Value) string {
+	return toString(v)
+}
+
+// throw a catchable error object of the given constructor name
+func (vm *VM) throwf(name string, format string, args ...interface{}) error {
+	return &ThrowError{newError(name, fmt.Sprintf(format, args...))}
+}
+
+func newError(name string, message string) *Object {
+	obj := newObject()
+	obj.class = "Error"
+	obj.set("name", name)
+	obj.set("message", message)
+	return obj
+}
+
+type scope struct {
+	vars   map[string]Value
+	parent *scope
+
+	// function scopes own var declarations and this
+	function bool
+	this     Value
+	hasThis  bool
+}
+
+func newScope(parent *scope, function bool) *scope {
+	return &scope{vars: map[string]Value{}, parent: parent, function: function}
+}
+
+func (s *scope) lookup(name string) (*scope, bool) {
+	for sc := s; sc != nil; sc = sc.parent {
+		if _, ok := sc.vars[name]; ok {
+			return sc, true
+		}
+	}
+	return nil, false
+}
+
+func (s *scope) thisValue() Value {
+	for sc := s; sc != nil; sc = sc.parent {
+		if sc.hasThis {
+			return sc.this
+		}
+	}
+	return Undefined
+}
+
+// declare var names and function declarations before running a body
+func (vm *VM) hoist(fn *funcLit, sc *scope) {
+	for _, name := range fn.vars {
+		if _, ok := sc.vars[name]; !ok {
+			sc.vars[name] = Undefined
+		}
+	}
+	for _, decl := range fn.funcs {
+		sc.vars[decl.name] = &Function{name: decl.name, lit: decl, env: sc}
+	}
+}
+
+type completionKind int
+
+const (
+	completionNormal completionKind = iota
+	completionReturn
+	completionBreak
+	completionContinue
+)
+
+type completion struct {
+	kind  completionKind
+	value Value
+	label string
+}
+
+var normal = completion{}
+
+func (vm *VM) execBlock(body []stmt, sc *scope) (completion, error) {
+	for _, s := range body {
+		c, err := vm.exec(s, sc)
+		if err != nil || c.kind != completionNormal {
+			return c, err
+		}
+	}
+	return normal, nil
+}
+
+func (vm *VM) exec(s stmt, sc *scope) (completion, error) {
+	if err := vm.step(); err != nil {
+		return normal, err
+	}
+
+	switch s := s.(type) {
+	case *exprStmt:
+		_, err := vm.eval(s.x, sc)
+		return normal, err
+
+	case *varDecl:
+		for i, name := range s.names {
+			target := sc
+			if s.kind == "var" {
+				for !target.function {
+					target = target.parent
+				}
+			}
+
+			if s.inits[i] == nil {
+				if s.kind != "var" {
+					sc.vars[name] = Undefined
+				}
+				continue
+			}
+
+			v, err := vm.eval(s.inits[i], sc)
+			if err != nil {
+				return normal, err
+			}
+			if fn, ok := v.(*Function); ok && fn.name == "" {
+				fn.name = name
+			}
+
+			if s.kind == "var" {
+				if owner, ok := sc.lookup(name); ok {
+					target = owner
+				}
+			}
+			target.vars[name] = v
+		}
+		return normal, nil
+
+	case *funcDecl, *emptyStmt:
+		return normal, nil
+
+	case *blockStmt:
+		return vm.execBlock(s.body, newScope(sc, false))
+
+	case *ifStmt:
+		test, err := vm.eval(s.test, sc)
+		if err != nil {
+			return normal, err
+		}
+		if toBool(test) {
+			return vm.exec(s.cons, sc)
+		} else if s.alt != nil {
+			return vm.exec(s.alt, sc)
+		}
+		return normal, nil
+
+	case *forStmt:
+		return vm.execFor(s, sc, "")
+	case *forInStmt:
+		return vm.execForIn(s, sc, "")
+	case *whileStmt:
+		return vm.execWhile(s.test, s.body, false, sc, "")
+	case *doWhileStmt:
+		return vm.execWhile(s.test, s.body, true, sc, "")
+
+	case *labeledStmt:
+		var c completion
+		var err error
+		switch body := s.body.(type) {
+		case *forStmt:
+			c, err = vm.execFor(body, sc, s.label)
+		case *forInStmt:
+			c, err = vm.execForIn(body, sc, s.label)
+		case *whileStmt:
+			c, err = vm.execWhile(body.test, body.body, false, sc, s.label)
+		case *doWhileStmt:
+			c, err = vm.execWhile(body.test, body.body, true, sc, s.label)
+		default:
+			c, err = vm.exec(body, sc)
+		}
+		if c.kind == completionBreak && c.label == s.label {
+			return normal, err
+		}
+		return c, err
+
+	case *returnStmt:
+		var v Value = Undefined
+		if s.x != nil {
+			var err error
+			if v, err = vm.eval(s.x, sc); err != nil {
+				return normal, err
+			}
+		}
+		return completion{kind: completionReturn, value: v}, nil
+
+	case *breakStmt:
+		return completion{kind: completionBreak, label: s.label}, nil
+	case *continueStmt:
+		return completion{kind: completionContinue, label: s.label}, nil
+
+	case *throwStmt:
+		v, err := vm.eval(s.x, sc)
+		if err != nil {
+			return normal, err
+		}
+		return normal, &ThrowError{v}
+
+	case *tryStmt:
+		return vm.execTry(s, sc)
+	case *switchStmt:
+		return vm.execSwitch(s, sc)
+	}
+
+	return normal, fmt.Errorf("unsupported statement %T", s)
+}
+
+// whether a loop body's completion ends the loop, and with what
+func loopControl(c completion, label string) (stop bool, result completion) {
+	switch c.kind {
+	case completionBreak:
+		if c.label == "" || c.label == label {
+			return true, normal
+		}
+		return true, c
+	case completionContinue:
+		if c.label == "" || c.label == label {
+			return false, normal
+		}
+		return true, c
+	case completionReturn:
+		return true, c
+	}
+	return false, normal
+}
+
+func (vm *VM) execFor(s *forStmt, sc *scope, label string) (completion, error) {
+	loopScope := newScope(sc, false)
+	if s.init != nil {
+		if _, err := vm.exec(s.init, loopScope); err != nil {
+			return normal, err
+		}
+	}
+
+	for {
+		if s.test != nil {
+			test, err := vm.eval(s.test, loopScope)
+			if err != nil {
+				return normal, err
+			}
+			if !toBool(test) {
+				return normal, nil
+			}
+		}
+
+		c, err := vm.exec(s.body, loopScope)
+		if err != nil {
+			return normal, err
+		}
+		if stop, result := loopControl(c, label); stop {
+			return result, nil
+		}
+
+		if s.update != nil {
+			if _, err := vm.eval(s.update, loopScope); err != nil {
+				return normal, err
+			}
+		}
+	}
+}
+
+func (vm *VM) execForIn(s *forInStmt, sc *scope, label string) (completion, error) {
+	obj, err := vm.eval(s.obj, sc)
+	if err != nil {
+		return normal, err
+	}
+
+	var items []Value
+	if s.of {
+		switch o := obj.(type) {
+		case *Array:
+			items = append(items, o.elems...)
+		case string:
+			for _, r := range o {
+				items = append(items, string(r))
+			}
+		default:
+			return normal, vm.throwf("TypeError", "%s is not iterable", toString(obj))
+		}
+	} else {
+		for _, key := range ownKeys(obj) {
+			items = append(items, key)
+		}
+	}
+
+	loopScope := newScope(sc, false)
+	for _, item := range items {
+		if s.decl != "" && s.decl != "var" {
+			loopScope.vars[s.target.(*ident).name] = item
+		} else if err := vm.assign(s.target, item, loopScope); err != nil {
+			return normal, err
+		}
+
+		c, err := vm.exec(s.body, loopScope)
+		if err != nil {
+			return normal, err
+		}
+		if stop, result := loopControl(c, label); stop {
+			return result, nil
+		}
+	}
+	return normal, nil
+}
+
+func (vm *VM) execWhile(test expr, body stmt, do bool, sc *scope, label string) (completion, error) {
+	for first := true; ; first = false {
+		if !(do && first) {
+			v, err := vm.eval(test, sc)
+			if err != nil {
+				return normal, err
+			}
+			if !toBool(v) {
+				return normal, nil
+			}
+		}
+
+		c, err := vm.exec(body, sc)
+		if err != nil {
+			return normal, err
+		}
+		if stop, result := loopControl(c, label); stop {
+			return result, nil
+		}
+	}
+}
+
+func (vm *VM) execTry(s *tryStmt, sc *scope) (completion, error) {
+	c, err := vm.execBlock(s.block.body, newScope(sc, false))
+
+	if err != nil && s.handler != nil {
+		// only script exceptions can be caught, not limits or cancellation
+		var thrown *ThrowError
+		if errors.As(err, &thrown) {
+			handlerScope := newScope(sc, false)
+			if s.param != "" {
+				handlerScope.vars[s.param] = thrown.Value
+			}
+			c, err = vm.execBlock(s.handler.body, handlerScope)
+		}
+	}
+
+	if s.finalizer != nil {
+		var thrown *ThrowError
+		if err != nil && !errors.As(err, &thrown) {
+			return c, err
+		}
+
+		fc, ferr := vm.execBlock(s.finalizer.body, newScope(sc, false))
+		if ferr != nil || fc.kind != completionNormal {
+			return fc, ferr
+		}
+	}
+
+	return c, err
+}
+
+func (vm *VM) execSwitch(s *switchStmt, sc *scope) (completion, error) {
+	disc, err := vm.eval(s.disc, sc)
+	if err != nil {
+		return normal, err
+	}
+
+	start := -1
+	for i, c := range s.cases {
+		if c.test == nil {
+			continue
+		}
+		v, err := vm.eval(c.test, sc)
+		if err != nil {
+			return normal, err
+		}
+		if strictEquals(disc, v) {
+			start = i
+			break
+		}
+	}
+
+	if start < 0 {
+		for i, c := range s.cases {
+			if c.test == nil {
+				start = i
+				break
+			}
+		}
+	}
+	if start < 0 {
+		return normal, nil
+	}
+
+	// fall through from the matched case
+	switchScope := newScope(sc, false)
+	for _, c := range s.cases[start:] {
+		res, err := vm.execBlock(c.body, switchScope)
+		if err != nil {
+			return normal, err
+		}
+		if res.kind == completionBreak && res.label == "" {
+			return normal, nil
+		}
+		if res.kind != completionNormal {
+			return res, nil
+		}
+	}
+	return normal, nil
+}
+
+func (vm *VM) eval(x expr, sc *scope) (Value, error) {
+	switch x := x.(type) {
+	case *numLit:
+		return x.value, nil
+	case *strLit:
+		return x.value, nil
+	case *boolLit:
+		return x.value, nil
+	case *nullLit:
+		return Null, nil
+	case *thisExpr:
+		return sc.thisValue(), nil
+
+	case *ident:
+		if owner, ok := sc.lookup(x.name); ok {
+			return owner.vars[x.name], nil
+		}
+		if x.name == "undefined" {
+			return Undefined, nil
+		}
+		return nil, vm.throwf("ReferenceError", "%s is not defined", x.name)
+
+	case *regexLit:
+		rx, err := compileRegExp(x.pattern, x.flags)
+		if err != nil {
+			return nil, vm.throwf("SyntaxError", "invalid regular expression /%s/: %v", x.pattern, err)
+		}
+		return rx, nil
+
+	case *arrayLit:
+		arr := newArray(make([]Value, 0, len(x.elems)))
+		for _, elem := range x.elems {
+			if elem == nil {
+				arr.elems = append(arr.elems, Undefined)
+				continue
+			}
+			if spread, ok := elem.(*spreadExpr); ok {
+				items, err := vm.spread(spread, sc)
+				if err != nil {
+					return nil, err
+				}
+				arr.elems = append(arr.elems, items...)
+				continue
+			}
+			v, err := vm.eval(elem, sc)
+			if err != nil {
+				return nil, err
+			}
+			arr.elems = append(arr.elems, v)
+		}
+		return arr, nil
+
+	case *objectLit:
+		obj := newObject()
+		for _, prop := range x.props {
+			v, err := vm.eval(prop.value, sc)
+			if err != nil {
+				return nil, err
+			}
+
+			if prop.spread {
+				for _, key := range ownKeys(v) {
+					pv, err := vm.getProp(v, key)
+					if err != nil {
+						return nil, err
+					}
+					obj.set(key, pv)
+				}
+				continue
+			}
+
+			key := prop.key
+			if prop.computed != nil {
+				k, err := vm.eval(prop.computed, sc)
+				if err != nil {
+					return nil, err
+				}
+				key = toPropertyKey(k)
+			}
+			obj.set(key, v)
+		}
+		return obj, nil
+
+	case *funcLit:
+		fn := &Function{name: x.name, lit: x, env: sc}
+		if x.name != "" && !x.arrow {
+			// named function expressions can refer to themselves
+			own := newScope(sc, false)
+			own.vars[x.name] = fn
+			fn.env = own
+		}
+		return fn, nil
+
+	case *unaryExpr:
+		return vm.evalUnary(x, sc)
+	case *updateExpr:
+		return vm.evalUpdate(x, sc)
+
+	case *binaryExpr:
+		a, err := vm.eval(x.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		b, err := vm.eval(x.y, sc)
+		if err != nil {
+			return nil, err
+		}
+		return vm.binary(x.op, a, b)
+
+	case *logicalExpr:
+		a, err := vm.eval(x.x, sc)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "&&":
+			if !toBool(a) {
+				return a, nil
+			}
+		case "||":
+			if toBool(a) {
+				return a, nil
+			}
+		case "??":
+			switch a.(type) {
+			case undefinedType, nullType:
+			default:
+				return a, nil
+			}
+		}
+		return vm.eval(x.y, sc)
+
+	case *assignExpr:
+		return vm.evalAssign(x, sc)
+
+	case *condExpr:
+		test, err := vm.eval(x.test, sc)
+		if err != nil {
+			return nil, err
+		}
+		if toBool(test) {
+			return vm.eval(x.cons, sc)
+		}
+		return vm.eval(x.alt, sc)
+
+	case *seqExpr:
+		var v Value = Undefined
+		for _, item := range x.list {
+			var err error
+			if v, err = vm.eval(item, sc); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+
+	case *memberExpr:
+		obj, err := vm.eval(x.obj, sc)
+		if err != nil {
+			return nil, err
+		}
+		if x.optional {
+			switch obj.(type) {
+			case undefinedType, nullType:
+				return Undefined, nil
+			}
+		}
+		key, err := vm.memberKey(x, sc)
+		if err != nil {
+			return nil, err
+		}
+		return vm.getProp(obj, key)
+
+	case *callExpr:
+		return vm.evalCall(x, sc)
+	case *newExpr:
+		return vm.evalNew(x, sc)
+	}
+
+	return nil, fmt.Errorf("unsupported expression %T", x)
+}
+
+func (vm *VM) memberKey(x *memberExpr, sc *scope) (Value, error) {
+	if x.computed == nil {
+		return x.prop, nil
+	}
+	return vm.eval(x.computed, sc)
+}
+
+func (vm *VM) spread(x *spreadExpr, sc *scope) ([]Value, error) {
+	v, err := vm.eval(x.x, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case *Array:
+		return append([]Value{}, v.elems...), nil
+	case string:
+		var items []Value
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+		return items, nil
+	}
+	return nil, vm.throwf("TypeError", "%s is not iterable", toString(v))
+}
+
+func (vm *VM) evalUnary(x *unaryExpr, sc *scope) (Value, error) {
+	switch x.op {
+	case "typeof":
+		// typeof tolerates undeclared variables
+		if id, ok := x.x.(*ident); ok {
+			if _, found := sc.lookup(id.name); !found {
+				return "undefined", nil
+			}
+		}
+	case "delete":
+		m, ok := x.x.(*memberExpr)
+		if !ok {
+			return true, nil
+		}
+		obj, err := vm.eval(m.obj, sc)
+		if err != nil {
+			return nil, err
+		}
+		key, err := vm.memberKey(m, sc)
+		if err != nil {
+			return nil, err
+		}
+		vm.deleteProp(obj, key)
+		return true, nil
+	}
+
+	v, err := vm.eval(x.x, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x.op {
+	case "typeof":
+		return typeOf(v), nil
+	case "void":
+		return Undefined, nil
+	case "!":
+		return !toBool(v), nil
+	case "-":
+		return -toNumber(v), nil
+	case "+":
+		return toNumber(v), nil
+	case "~":
+		return float64(^toInt32(v)), nil
+	}
+	return nil, fmt.Errorf("unsupported unary operator %s", x.op)
+}
+
+func (vm *VM) evalUpdate(x *updateExpr, sc *scope) (Value, error) {
+	old, err := vm.eval(x.x, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	n := toNumber(old)
+	updated := n + 1
+	if x.op == "--" {
+		updated = n - 1
+	}
+
+	if err := vm.assign(x.x, updated, sc); err != nil {
+		return nil, err
+	}
+	if x.prefix {
+		return updated, nil
+	}
+	return n, nil
+}
+
+func (vm *VM) evalAssign(x *assignExpr, sc *scope) (Value, error) {
+	if x.op == "=" {
+		v, err := vm.eval(x.value, sc)
+		if err != nil {
+			return nil, err
+		}
+		return v, vm.assign(x.target, v, sc)
+	}
+
+	old, err := vm.eval(x.target, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	// logical assignment only evaluates the right side when needed
+	switch x.op {
+	case "&&=", "||=", "??=":
+		keep := false
+		switch x.op {
+		case "&&=":
+			keep = !toBool(old)
+		case "||=":
+			keep = toBool(old)
+		case "??=":
+			switch old.(type) {
+			case undefinedType, nullType:
+			default:
+				keep = true
+			}
+		}
+		if keep {
+			return old, nil
+		}
+		v, err := vm.eval(x.value, sc)
+		if err != nil {
+			return nil, err
+		}
+		return v, vm.assign(x.target, v, sc)
+	}
+
+	v, err := vm.eval(x.value, sc)
+	if err != nil {
+		return nil, err
+	}
+	result, err := vm.binary(x.op[:len(x.op)-1], old, v)
+	if err != nil {
+		return nil, err
+	}
+	return result, vm.assign(x.target, result, sc)
+}
+
+// store v into an identifier or member target
+func (vm *VM) assign(target expr, v Value, sc *scope) error {
+	switch t := target.(type) {
+	case *ident:
+		if owner, ok := sc.lookup(t.name); ok {
+			owner.vars[t.name] = v
+		} else {
+			// sloppy mode creates a global
+			vm.global.vars[t.name] = v
+		}
+		return nil
+
+	case *memberExpr:
+		obj, err := vm.eval(t.obj, sc)
+		if err != nil {
+			return err
+		}
+		key, err := vm.memberKey(t, sc)
+		if err != nil {
+			return err
+		}
+		return vm.setProp(obj, key, v)
+	}
+	return vm.throwf("SyntaxError", "invalid assignment target")
+}
+
+func (vm *VM) evalCall(x *callExpr, sc *scope) (Value, error) {
+	var fn Value
+	var this Value = Undefined
+
+	if m, ok := x.callee.(*memberExpr); ok {
+		obj, err := vm.eval(m.obj, sc)
+		if err != nil {
+			return nil, err
+		}
+		if m.optional {
+			switch obj.(type) {
+			case undefinedType, nullType:
+				return Undefined, nil
+			}
+		}
+		key, err := vm.memberKey(m, sc)
+		if err != nil {
+			return nil, err
+		}
+		if fn, err = vm.getProp(obj, key); err != nil {
+			return nil, err
+		}
+		this = obj
+
+		if _, ok := fn.(*Function); !ok && !x.optional {
+			return nil, vm.throwf("TypeError", "%s is not a function", describeCallee(m, key))
+		}
+	} else {
+		var err error
+		if fn, err = vm.eval(x.callee, sc); err != nil {
+			return nil, err
+		}
+	}
+
+	if x.optional {
+		switch fn.(type) {
+		case undefinedType, nullType:
+			return Undefined, nil
+		}
+	}
+
+	args, err := vm.evalArgs(x.args, sc)
+	if err != nil {
+		return nil, err
+	}
+	return vm.call(fn, this, args)
+}
+
+func describeCallee(m *memberExpr, key Value) string {
+	if id, ok := m.obj.(*ident); ok {
+		return id.name + "." + toString(key)
+	}
+	return toString(key)
+}
+
+func (vm *VM) evalArgs(list []expr, sc *scope) ([]Value, error) {
+	args := make([]Value, 0, len(list))
+	for _, arg := range list {
+		if spread, ok := arg.(*spreadExpr); ok {
+			items, err := vm.spread(spread, sc)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, items...)
+			continue
+		}
+
+		v, err := vm.eval(arg, sc)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	return args, nil
+}
+
+func (vm *VM) evalNew(x *newExpr, sc *scope) (Value, error) {
+	callee, err := vm.eval(x.callee, sc)
+	if err != nil {
+		return nil, err
+	}
+	args, err := vm.evalArgs(x.args, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	fn, ok := callee.(*Function)
+	if !ok {
+		return nil, vm.throwf("TypeError", "%s is not a constructor", toString(callee))
+	}
+	if fn.construct != nil {
+		return fn.construct(vm, args)
+	}
+	if fn.native != nil || fn.lit.arrow {
+		return nil, vm.throwf("TypeError", "%s is not a constructor", fn.name)
+	}
+
+	obj := newObject()
+	result, err := vm.call(fn, obj, args)
+	if err != nil {
+		return nil, err
+	}
+	if isObjectLike(result) {
+		return result, nil
+	}
+	return obj, nil
+}
+
+func (vm *VM) call(callee Value, this Value, args []Value) (Value, error) {
+	fn, ok := callee.(*Function)
+	if !ok {
+		return nil, vm.throwf("TypeError", "%s is not a function", toString(callee))
+	}
+
+	if err := vm.step(); err != nil {
+		return nil, err
+	}
+	if vm.MaxDepth > 0 && vm.depth >= vm.MaxDepth {
+		return nil, ErrDepthLimit
+	}
+	vm.depth++
+	defer func() { vm.depth-- }()
+
+	if fn.native != nil {
+		return fn.native(vm, this, args)
+	}
+
+	lit := fn.lit
+	sc := newScope(fn.env, true)
+	if !lit.arrow {
+		sc.hasThis = true
+		sc.this = this
+		sc.vars["arguments"] = newArray(append([]Value{}, args...))
+	}
+
+	for i, prm := range lit.params {
+		if prm.rest {
+			rest := []Value{}
+			if i < len(args) {
+				rest = append(rest, args[i:]...)
+			}
+			sc.vars[prm.name] = newArray(rest)
+			break
+		}
+
+		var v Value = Undefined
+		if i < len(args) {
+			v = args[i]
+		}
+		if _, isUndefined := v.(undefinedType); isUndefined && prm.defaultVal != nil {
+			var err error
+			if v, err = vm.eval(prm.defaultVal, sc); err != nil {
+				return nil, err
+			}
+		}
+		sc.vars[prm.name] = v
+	}
+
+	if lit.exprBody != nil {
+		return vm.eval(lit.exprBody, sc)
+	}
+
+	vm.hoist(lit, sc)
+	c, err := vm.execBlock(lit.body, sc)
+	if err != nil {
+		return nil, err
+	}
+	if c.kind == completionReturn {
+		return c.value, nil
+	}
+	return Undefined, nil
+}
+
+// toPrimitive, throwing a RangeError for arrays that can't be joined
+func (vm *VM) primitive(v Value) (Value, error) {
+	arr, ok := v.(*Array)
+	if !ok {
+		return toPrimitive(v), nil
+	}
+
+	joined, err := joinArray(arr, ",", nil)
+	if err != nil {
+		return nil, vm.throwf("RangeError", "%v", err)
+	}
+	return joined, nil
+}
+
+func (vm *VM) binary(op string, a Value, b Value) (Value, error) {
+	switch op {
+	case "+":
+		var err error
+		if a, err = vm.primitive(a); err != nil {
+			return nil, err
+		}
+		if b, err = vm.primitive(b); err != nil {
+			return nil, err
+		}
+		as, aStr := a.(string)
+		bs, bStr := b.(string)
+		if aStr || bStr {
+			if !aStr {
+				as = toString(a)
+			}
+			if !bStr {
+				bs = toString(b)
+			}
+			if len(as)+len(bs) > maxLength {
+				return nil, vm.throwf("RangeError", "invalid string length")
+			}
+			return as + bs, nil
+		}
+		return toNumber(a) + toNumber(b), nil
+	case "-":
+		return toNumber(a) - toNumber(b), nil
+	case "*":
+		return toNumber(a) * toNumber(b), nil
+	case "/":
+		return toNumber(a) / toNumber(b), nil
+	case "%":
+		x, y := toNumber(a), toNumber(b)
+		if y == 0 || math.IsInf(x, 0) {
+			return math.NaN(), nil
+		}
+		if math.IsInf(y, 0) {
+			return x, nil
+		}
+		return math.Mod(x, y), nil
+	case "**":
+		return math.Pow(toNumber(a), toNumber(b)), nil
+
+	case "&":
+		return float64(toInt32(a) & toInt32(b)), nil
+	case "|":
+		return float64(toInt32(a) | toInt32(b)), nil
+	case "^":
+		return float64(toInt32(a) ^ toInt32(b)), nil
+	case "<<":
+		return float64(toInt32(a) << (toUint32(b) & 31)), nil
+	case ">>":
+		return float64(toInt32(a) >> (toUint32(b) & 31)), nil
+	case ">>>":
+		return float64(toUint32(a) >> (toUint32(b) & 31)), nil
+
+	case "===":
+		return strictEquals(a, b), nil
+	case "!==":
+		return !strictEquals(a, b), nil
+	case "==":
+		return looseEquals(a, b), nil
+	case "!=":
+		return !looseEquals(a, b), nil
+
+	case "<", ">", "<=", ">=":
+		return compare(op, a, b), nil
+
+	case "in":
+		if !isObjectLike(b) {
+			return nil, vm.throwf("TypeError", "cannot use 'in' operator to search for '%s' in %s", toString(a), toString(b))
+		}
+		return vm.hasProp(b, a), nil
+
+	case "instanceof":
+		fn, ok := b.(*Function)
+		if !ok {
+			return nil, vm.throwf("TypeError", "right-hand side of 'instanceof' is not callable")
+		}
+		return instanceOf(a, fn), nil
+	}
+
+	return nil, fmt.Errorf("unsupported operator %s", op)
+}
+
+func compare(op string, a Value, b Value) bool {
+	a, b = toPrimitive(a), toPrimitive(b)
+
+	as, aStr := a.(string)
+	bs, bStr := b.(string)
+	if aStr && bStr {
+		switch op {
+		case "<":
+			return as < bs
+		case ">":
+			return as > bs
+		case "<=":
+			return as <= bs
+		}
+		return as >= bs
+	}
+
+	x, y := toNumber(a), toNumber(b)
+	switch op {
+	case "<":
+		return x < y
+	case ">":
+		return x > y
+	case "<=":
+		return x <= y
+	}
+	return x >= y
+}
+
+// instanceof against the builtin constructors, user objects have no prototypes
+func instanceOf(v Value, fn *Function) bool {
+	switch fn.name {
+	case "Array":
+		_, ok := v.(*Array)
+		return ok
+	case "Function":
+		_, ok := v.(*Function)
+		return ok
+	case "RegExp":
+		_, ok := v.(*RegExp)
+		return ok
+	case "Object":
+		return isObjectLike(v)
+	case "Error", "TypeError", "RangeError", "ReferenceError", "SyntaxError":
+		obj, ok := v.(*Object)
+		if !ok || obj.class != "Error" {
+			return false
+		}
+		name, _ := obj.get("name")
+		return fn.name == "Error" || toString(name) == fn.name
+	case "Date":
+		obj, ok := v.(*Object)
+		return ok && obj.class == "Date"
+	}
+	return false
+}
+
+func (vm *VM) getProp(obj Value, key Value) (Value, error) {
+	switch o := obj.(type) {
+	case undefinedType, nullType:
+		return nil, vm.throwf("TypeError", "cannot read properties of %s (reading '%s')", toString(obj), toPropertyKey(key))
+
+	case string:
+		if i := arrayIndex(key); i >= 0 {
+			code := strCodeAt(o, i)
+			if code < 0 {
+				return Undefined, nil
+			}
+			return strSlice(o, i, i+1), nil
+		}
+		name := toPropertyKey(key)
+		if name == "length" {
+			return float64(strLen(o)), nil
+		}
+		if m, ok := stringMethods[name]; ok {
+			return m, nil
+		}
+		return Undefined, nil
+
+	case *Array:
+		if i := arrayIndex(key); i >= 0 {
+			if i < len(o.elems) {
+				return o.elems[i], nil
+			}
+			return Undefined, nil
+		}
+		name := toPropertyKey(key)
+		if name == "length" {
+			return float64(len(o.elems)), nil
+		}
+		if o.props != nil {
+			if v, ok := o.props.get(name); ok {
+				return v, nil
+			}
+		}
+		if m, ok := arrayMethods[name]; ok {
+			return m, nil
+		}
+		return vm.objectMethod(name), nil
+
+	case *Object:
+		name := toPropertyKey(key)
+		if v, ok := o.get(name); ok {
+			return v, nil
+		}
+		if o.class == "Date" {
+			if m, ok := dateMethods[name]; ok {
+				return m, nil
+			}
+		}
+		return vm.objectMethod(name), nil
+
+	case *Function:
+		name := toPropertyKey(key)
+		if o.props != nil {
+			if v, ok := o.props.get(name); ok {
+				return v, nil
+			}
+		}
+		switch name {
+		case "length":
+			if o.lit != nil {
+				return float64(len(o.lit.params)), nil
+			}
+			return 0.0, nil
+		case "name":
+			return o.name, nil
+		}
+		if m, ok := functionMethods[name]; ok {
+			return m, nil
+		}
+		return vm.objectMethod(name), nil
+
+	case *RegExp:
+		name := toPropertyKey(key)
+		switch name {
+		case "source":
+			return o.source, nil
+		case "flags":
+			return o.flags, nil
+		case "global":
+			return o.global(), nil
+		case "lastIndex":
+			return float64(o.lastIndex), nil
+		}
+		if m, ok := regexpMethods[name]; ok {
+			return m, nil
+		}
+		return vm.objectMethod(name), nil
+
+	case float64:
+		if m, ok := numberMethods[toPropertyKey(key)]; ok {
+			return m, nil
+		}
+		return Undefined, nil
+
+	case bool:
+		if toPropertyKey(key) == "toString" {
+			return objectMethods["toString"], nil
+		}
+		return Undefined, nil
+	}
+
+	return Undefined, nil
+}
+
+func (vm *VM) objectMethod(name string) Value {
+	if m, ok := objectMethods[name]; ok {
+		return m
+	}
+	return Undefined
+}
+
+func (vm *VM) setProp(obj Value, key Value, v Value) error {
+	switch o := obj.(type) {
+	case undefinedType, nullType:
+		return vm.throwf("TypeError", "cannot set properties of %s (setting '%s')", toString(obj), toPropertyKey(key))
+
+	case *Array:
+		if i := arrayIndex(key); i >= 0 {
+			if i >= len(o.elems) {
+				if i-len(o.elems) > maxLength {
+					return vm.throwf("RangeError", "array too large")
+				}
+				for len(o.elems) <= i {
+					o.elems = append(o.elems, Undefined)
+				}
+			}
+			o.elems[i] = v
+			return nil
+		}
+
+		name := toPropertyKey(key)
+		if name == "length" {
+			n := toNumber(v)
+			if n < 0 || !isIntegral(n) || n > maxLength {
+				return vm.throwf("RangeError", "invalid array length")
+			}
+			length := int(n)
+			for len(o.elems) < length {
+				o.elems = append(o.elems, Undefined)
+			}
+			o.elems = o.elems[:length]
+			return nil
+		}
+
+		if o.props == nil {
+			o.props = newObject()
+		}
+		o.props.set(name, v)
+
+	case *Object:
+		o.set(toPropertyKey(key), v)
+	case *Function:
+		o.properties().set(toPropertyKey(key), v)
+	case *RegExp:
+		if toPropertyKey(key) == "lastIndex" {
+			o.lastIndex = int(toInteger(v))
+		}
+	}
+
+	// assignments to primitives are silently ignored
+	return nil
+}
+
+func (vm *VM) deleteProp(obj Value, key Value) {
+	switch o := obj.(type) {
+	case *Array:
+		if i := arrayIndex(key); i >= 0 {
+			if i < len(o.elems) {
+				o.elems[i] = Undefined
+			}
+			return
+		}
+		if o.props != nil {
+			o.props.delete(toPropertyKey(key))
+		}
+	case *Object:
+		o.delete(toPropertyKey(key))
+	case *Function:
+		if o.props != nil {
+			o.props.delete(toPropertyKey(key))
+		}
+	}
+}
+
+func (vm *VM) hasProp(obj Value, key Value) bool {
+	switch o := obj.(type) {
+	case *Array:
+		if i := arrayIndex(key); i >= 0 {
+			return i < len(o.elems)
+		}
+		name := toPropertyKey(key)
+		if name == "length" {
+			return true
+		}
+		if o.props != nil {
+			if _, ok := o.props.get(name); ok {
+				return true
+			}
+		}
+		_, ok := arrayMethods[name]
+		return ok
+	case *Object:
+		_, ok := o.get(toPropertyKey(key))
+		return ok
+	case *Function:
+		if o.props != nil {
+			_, ok := o.props.get(toPropertyKey(key))
+			return ok
+		}
+	}
+	return false
+}
+
+// index argument relative to length, as used by slice and friends
+func relativeIndex(v Value, length int) int {
+	if _, ok := v.(undefinedType); ok {
+		return 0
+	}
+	f := toInteger(v)
+	if f < 0 {
+		f += float64(length)
+		if f < 0 {
+			f = 0
+		}
+	}
+	if f > float64(length) {
+		f = float64(length)
+	}
+	return int(f)
+}
+
+func argOr(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return Undefined
+}
+
+func isUndefined(v Value) bool {
+	_, ok := v.(undefinedType)
+	return ok
+}
@@ -0,0 +1,183 @@
+package jsinterp
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`1+2*3-4/2`, "5"},
+		{`7%3+(-7%3)+2**10`, "1024"},
+		{`(5>>>1)|(1<<4)^3&~0`, "19"},
+		{`"a"+1+2`, "a12"},
+		{`1+2+"a"`, "3a"},
+		{`"3"*"4"`, "12"},
+		{`0.1+0.2`, "0.30000000000000004"},
+		{`1/0`, "Infinity"},
+		{`typeof null+typeof void 0+typeof function(){}`, "objectundefinedfunction"},
+		{`"abcdef".split("").reverse().join("")`, "fedcba"},
+		{`var a=[1,2,3,4,5];a.splice(1,2);a.join()`, "1,4,5"},
+		{`[3,1,2].sort().concat([9]).indexOf(9)`, "3"},
+		{`[1,2,3].map(function(x){return x*2}).filter(function(x){return x>2})`, "4,6"},
+		{`"hello".charCodeAt(1)+String.fromCharCode(72)`, "101H"},
+		{`"a-b_c".replace(/[-_]/g,"+")`, "a+b+c"},
+		{`"x1y22z".match(/\d+/g).length`, "2"},
+		{`var f=function(n){return n<2?n:f(n-1)+f(n-2)};f(15)`, "610"},
+		{`function counter(){var i=0;return function(){return ++i}};var c=counter();c();c();c()`, "3"},
+		{`var s="";for(var k in {a:1,b:2})s+=k;s`, "ab"},
+		{`var r=0;for(var i=0;i<10;i++){if(i==3)continue;if(i==6)break;r+=i}r`, "12"},
+		{`var x;switch(2){case 1:x="one";case 2:x="two";case 3:x+="three";break;default:x="none"}x`, "twothree"},
+		{`var v;try{null.x}catch(e){v=e instanceof TypeError}v`, "true"},
+		{`var v;try{throw new Error("boom")}catch(e){v=e.message}finally{v+="!"}v`, "boom!"},
+		{`var o={a:1};o.b=o.a+1;o["c"]=3;Object.keys(o).join("")`, "abc"},
+		{`var f=(a,b=2,...c)=>a+b+c.length;f(1)+f(1,1,1,1)`, "7"},
+		{`[1,2,1,2].lastIndexOf(2)+","+[1,2,1,2].lastIndexOf(2,2)+","+[1,2,1,2].lastIndexOf(2,9)`, "3,1,3"},
+		{`[1,2,1,2].lastIndexOf(2,-1)+","+[1,2,1,2].lastIndexOf(2,-2)+","+[1,2,1,2].lastIndexOf(1,-4)`, "3,1,0"},
+		{`[1,2,1,2].lastIndexOf(2,-4)+","+[1,2,1,2].lastIndexOf(1,-9)+","+[].lastIndexOf(1,-1)`, "-1,-1,-1"},
+	}
+
+	for _, test := range tests {
+		got, err := New().Run(context.Background(), test.src)
+		if err != nil {
+			t.Errorf("Run(%q): %v", test.src, err)
+			continue
+		}
+		if ToString(got) != test.want {
+			t.Errorf("Run(%q) = %q, want %q", test.src, ToString(got), test.want)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	vm := New()
+	_, err := vm.Run(context.Background(), `var calls=0;function f(a,b){calls++;return a+":"+b}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := vm.Call(context.Background(), "f", "x", 2.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ToString(got) != "x:2" {
+		t.Errorf("f(x, 2) = %q, want x:2", ToString(got))
+	}
+
+	// globals outlive a call
+	vm.Call(context.Background(), "f")
+	if calls, _ := vm.Get("calls"); ToString(calls) != "2" {
+		t.Errorf("calls = %v, want 2", calls)
+	}
+
+	vm.Set("g", "set from go")
+	if got, _ := vm.Run(context.Background(), `g`); ToString(got) != "set from go" {
+		t.Errorf("g = %q, want the value set", ToString(got))
+	}
+
+	if _, err := vm.Call(context.Background(), "missing"); err == nil {
+		t.Error("calling an undefined function didn't fail")
+	}
+	if _, err := vm.Call(context.Background(), "calls"); err == nil {
+		t.Error("calling a number didn't fail")
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	var syntaxErr *SyntaxError
+	for _, src := range []string{`var = 1`, `function(){`, `"unterminated`, `a +* b`} {
+		_, err := New().Run(context.Background(), src)
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Run(%q) error = %v, want a SyntaxError", src, err)
+		}
+	}
+
+	var throwErr *ThrowError
+	for _, src := range []string{`throw "oops"`, `undefinedName+1`, `null.x`, `(void 0)()`} {
+		_, err := New().Run(context.Background(), src)
+		if !errors.As(err, &throwErr) {
+			t.Errorf("Run(%q) error = %v, want a ThrowError", src, err)
+		}
+	}
+
+	_, err := New().Run(context.Background(), `throw new RangeError("out of range")`)
+	if err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("thrown error = %v, want its message", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	vm := New()
+	vm.MaxSteps = 10000
+	if _, err := vm.Run(context.Background(), `while(true){}`); !errors.Is(err, ErrStepLimit) {
+		t.Errorf("endless loop error = %v, want ErrStepLimit", err)
+	}
+
+	// the budget is per run, so the vm is still usable
+	if got, err := vm.Run(context.Background(), `1+1`); err != nil || ToString(got) != "2" {
+		t.Errorf("run after the step limit = %v, %v", got, err)
+	}
+
+	vm = New()
+	vm.MaxDepth = 32
+	if _, err := vm.Run(context.Background(), `function f(n){return f(n+1)}f(0)`); !errors.Is(err, ErrDepthLimit) {
+		t.Errorf("endless recursion error = %v, want ErrDepthLimit", err)
+	}
+	if _, err := vm.Run(context.Background(), `function g(n){return n?g(n-1):0}g(20)`); err != nil {
+		t.Errorf("recursion within the limit: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	vm = New()
+	vm.MaxSteps = 0
+	if _, err := vm.Run(ctx, `while(true){}`); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled run error = %v, want context.Canceled", err)
+	}
+
+	if _, err := New().Run(context.Background(), `var s="ab";while(true)s+=s`); err == nil {
+		t.Error("unbounded string growth didn't fail")
+	}
+}
+
+func TestArrayCycles(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`var a=[];a.push(a);a.join()`, ""},
+		{`var a=[1,2];a.push(a);""+a`, "1,2,"},
+		{`var a=[1];var b=[a,2];a.push(b);String(a)+"|"+b.join("-")`, "1,,2|1,-2"},
+		// n transforms keep their dispatch array inside itself
+		{`var c=[function(){},"x"];c[2]=c;c.toString()`, "function(){},x,"},
+		{`var a=[1];[a,a,[a]].join(";")`, "1;1;1"},
+	}
+
+	for _, test := range tests {
+		got, err := New().Run(context.Background(), test.src)
+		if err != nil {
+			t.Errorf("Run(%q): %v", test.src, err)
+			continue
+		}
+		if ToString(got) != test.want {
+			t.Errorf("Run(%q) = %q, want %q", test.src, ToString(got), test.want)
+		}
+	}
+
+	var throwErr *ThrowError
+	for _, src := range []string{
+		`var a=[];for(var i=0;i<5000;i++)a=[a];a.join()`,
+		`var a=[];for(var i=0;i<5000;i++)a=[a];""+a`,
+		`var a=["abcdefgh"];for(var i=0;i<30;i++)a=[a,a];String(a)`,
+	} {
+		_, err := New().Run(context.Background(), src)
+		if !errors.As(err, &throwErr) || !strings.Contains(err.Error(), "RangeError") {
+			t.Errorf("Run(%q) error = %v, want a RangeError", src, err)
+		}
+	}
+}
@@ -0,0 +1,526 @@
+package jsinterp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNum
+	tokStr
+	tokTemplate
+	tokRegex
+	tokPunct
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	num   float64
+	flags string // regex flags
+	pos   int
+	end   int
+
+	// a line break separates this token from the previous one
+	newline bool
+}
+
+// longest first so that e.g. ">>>=" wins over ">>"
+var punctuators = []string{
+	">>>=", "...", "===", "!==", "**=", "<<=", ">>=", ">>>", "&&=", "||=", "??=",
+	"=>", "==", "!=", "<=", ">=", "&&", "||", "??", "?.", "++", "--", "+=", "-=",
+	"*=", "/=", "%=", "&=", "|=", "^=", "<<", ">>", "**",
+	"{", "}", "(", ")", "[", "]", ";", ",", "<", ">", "+", "-", "*", "/", "%",
+	"&", "|", "^", "!", "~", "?", ":", "=", ".",
+}
+
+// keywords after which a '/' starts a regex rather than a division
+var regexKeywords = map[string]bool{
+	"return": true, "typeof": true, "case": true, "in": true, "of": true,
+	"new": true, "delete": true, "void": true, "throw": true, "else": true,
+	"do": true, "instanceof": true,
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 0x7f
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || r >= '0' && r <= '9'
+}
+
+// SyntaxError is returned for source the parser can't handle.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d: %s", e.Pos, e.Msg)
+}
+
+type lexer struct {
+	src    string
+	pos    int
+	tokens []token
+}
+
+// tokenize splits src into tokens, ending with a tokEOF token.
+func tokenize(src string) ([]token, error) {
+	lx := &lexer{src: src}
+	for {
+		tok, err := lx.next()
+		if err != nil {
+			return nil, err
+		}
+		lx.tokens = append(lx.tokens, tok)
+		if tok.kind == tokEOF {
+			return lx.tokens, nil
+		}
+	}
+}
+
+// whether a '/' at this point starts a regex literal
+func (lx *lexer) regexAllowed() bool {
+	if len(lx.tokens) == 0 {
+		return true
+	}
+
+	prev := lx.tokens[len(lx.tokens)-1]
+	switch prev.kind {
+	case tokNum, tokStr, tokTemplate, tokRegex:
+		return false
+	case tokIdent:
+		return regexKeywords[prev.value]
+	case tokPunct:
+		return prev.value != ")" && prev.value != "]" && prev.value != "}"
+	}
+	return true
+}
+
+// skip whitespace and comments, reporting whether a line break was seen
+func (lx *lexer) skipSpace() (bool, error) {
+	newline := false
+	for lx.pos < len(lx.src) {
+		c := lx.src[lx.pos]
+		switch {
+		case c == '\n' || c == '\r':
+			newline = true
+			lx.pos++
+		case c == ' ' || c == '\t' || c == '\v' || c == '\f':
+			lx.pos++
+		case strings.HasPrefix(lx.src[lx.pos:], "//"):
+			end := strings.IndexAny(lx.src[lx.pos:], "\r\n")
+			if end < 0 {
+				lx.pos = len(lx.src)
+			} else {
+				lx.pos += end
+			}
+		case strings.HasPrefix(lx.src[lx.pos:], "/*"):
+			end := strings.Index(lx.src[lx.pos+2:], "*/")
+			if end < 0 {
+				return newline, &SyntaxError{lx.pos, "unterminated comment"}
+			}
+			if strings.ContainsAny(lx.src[lx.pos:lx.pos+2+end], "\r\n") {
+				newline = true
+			}
+			lx.pos += end + 4
+		default:
+			r, size := utf8.DecodeRuneInString(lx.src[lx.pos:])
+			// no-break space, BOM and the unicode line terminators
+			if r == 0xa0 || r == 0xfeff {
+				lx.pos += size
+			} else if r == 0x2028 || r == 0x2029 {
+				newline = true
+				lx.pos += size
+			} else {
+				return newline, nil
+			}
+		}
+	}
+	return newline, nil
+}
+
+func (lx *lexer) next() (token, error) {
+	newline, err := lx.skipSpace()
+	if err != nil {
+		return token{}, err
+	}
+
+	start := lx.pos
+	tok := token{pos: start, newline: newline}
+	if lx.pos >= len(lx.src) {
+		tok.kind = tokEOF
+		tok.end = lx.pos
+		return tok, nil
+	}
+
+	c := lx.src[lx.pos]
+	r, _ := utf8.DecodeRuneInString(lx.src[lx.pos:])
+
+	switch {
+	case isIdentStart(r) || c == '\\':
+		name, err := lx.readIdent()
+		if err != nil {
+			return tok, err
+		}
+		tok.kind = tokIdent
+		tok.value = name
+
+	case c >= '0' && c <= '9' || c == '.' && lx.pos+1 < len(lx.src) && lx.src[lx.pos+1] >= '0' && lx.src[lx.pos+1] <= '9':
+		num, err := lx.readNumber()
+		if err != nil {
+			return tok, err
+		}
+		tok.kind = tokNum
+		tok.num = num
+
+	case c == '"' || c == '\'':
+		str, err := lx.readString(c)
+		if err != nil {
+			return tok, err
+		}
+		tok.kind = tokStr
+		tok.value = str
+
+	case c == '`':
+		str, err := lx.readTemplate()
+		if err != nil {
+			return tok, err
+		}
+		tok.kind = tokTemplate
+		tok.value = str
+
+	case c == '/' && lx.regexAllowed():
+		pattern, flags, err := lx.readRegex()
+		if err != nil {
+			return tok, err
+		}
+		tok.kind = tokRegex
+		tok.value = pattern
+		tok.flags = flags
+
+	default:
+		for _, p := range punctuators {
+			if strings.HasPrefix(lx.src[lx.pos:], p) {
+				// "a?.5:b" is a conditional, not optional chaining
+				if p == "?." && lx.pos+2 < len(lx.src) && lx.src[lx.pos+2] >= '0' && lx.src[lx.pos+2] <= '9' {
+					continue
+				}
+				tok.kind = tokPunct
+				tok.value = p
+				lx.pos += len(p)
+				break
+			}
+		}
+		if tok.kind != tokPunct {
+			return tok, &SyntaxError{lx.pos, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+
+	tok.end = lx.pos
+	return tok, nil
+}
+
+func (lx *lexer) readIdent() (string, error) {
+	var sb strings.Builder
+	for lx.pos < len(lx.src) {
+		r, size := utf8.DecodeRuneInString(lx.src[lx.pos:])
+		if r == '\\' {
+			// \uXXXX escapes are allowed in identifiers
+			if !strings.HasPrefix(lx.src[lx.pos:], "\\u") {
+				return "", &SyntaxError{lx.pos, "invalid escape in identifier"}
+			}
+			lx.pos++
+			code, err := lx.readUnicodeEscape()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteRune(code)
+			continue
+		}
+		if !isIdentPart(r) {
+			break
+		}
+		sb.WriteRune(r)
+		lx.pos += size
+	}
+	return sb.String(), nil
+}
+
+func (lx *lexer) readNumber() (float64, error) {
+	start := lx.pos
+	src := lx.src
+
+	if src[lx.pos] == '0' && lx.pos+1 < len(src) {
+		base := 0
+		switch src[lx.pos+1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+
+		if base != 0 {
+			lx.pos += 2
+			digits := lx.pos
+			for lx.pos < len(src) && (isHexDigit(src[lx.pos]) || src[lx.pos] == '_') {
+				lx.pos++
+			}
+			str := strings.ReplaceAll(src[digits:lx.pos], "_", "")
+			n, err := strconv.ParseUint(str, base, 64)
+			if err != nil {
+				return 0, &SyntaxError{start, "invalid number"}
+			}
+			return float64(n), nil
+		}
+	}
+
+	for lx.pos < len(src) && (src[lx.pos] >= '0' && src[lx.pos] <= '9' || src[lx.pos] == '_') {
+		lx.pos++
+	}
+	if lx.pos < len(src) && src[lx.pos] == '.' {
+		lx.pos++
+		for lx.pos < len(src) && (src[lx.pos] >= '0' && src[lx.pos] <= '9' || src[lx.pos] == '_') {
+			lx.pos++
+		}
+	}
+	if lx.pos < len(src) && (src[lx.pos] == 'e' || src[lx.pos] == 'E') {
+		lx.pos++
+		if lx.pos < len(src) && (src[lx.pos] == '+' || src[lx.pos] == '-') {
+			lx.pos++
+		}
+		for lx.pos < len(src) && src[lx.pos] >= '0' && src[lx.pos] <= '9' {
+			lx.pos++
+		}
+	}
+
+	str := strings.ReplaceAll(src[start:lx.pos], "_", "")
+
+	// legacy octal literals like 010
+	if len(str) > 1 && str[0] == '0' && !strings.ContainsAny(str, ".eE89") {
+		n, err := strconv.ParseUint(str[1:], 8, 64)
+		if err == nil {
+			return float64(n), nil
+		}
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		// ParseFloat reports overflow but still returns ±Inf
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			return n, nil
+		}
+		return 0, &SyntaxError{start, "invalid number"}
+	}
+	return n, nil
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// read the hex digits of a \u escape, the position being just past the 'u'
+func (lx *lexer) readUnicodeEscape() (rune, error) {
+	lx.pos++ // 'u'
+	if lx.pos < len(lx.src) && lx.src[lx.pos] == '{' {
+		end := strings.IndexByte(lx.src[lx.pos:], '}')
+		if end < 0 {
+			return 0, &SyntaxError{lx.pos, "invalid unicode escape"}
+		}
+		n, err := strconv.ParseUint(lx.src[lx.pos+1:lx.pos+end], 16, 32)
+		if err != nil {
+			return 0, &SyntaxError{lx.pos, "invalid unicode escape"}
+		}
+		lx.pos += end + 1
+		return rune(n), nil
+	}
+
+	if lx.pos+4 > len(lx.src) {
+		return 0, &SyntaxError{lx.pos, "invalid unicode escape"}
+	}
+	n, err := strconv.ParseUint(lx.src[lx.pos:lx.pos+4], 16, 32)
+	if err != nil {
+		return 0, &SyntaxError{lx.pos, "invalid unicode escape"}
+	}
+	lx.pos += 4
+	return rune(n), nil
+}
+
+// read an escape sequence, the position being just past the backslash
+func (lx *lexer) readEscape(sb *strings.Builder) error {
+	if lx.pos >= len(lx.src) {
+		return &SyntaxError{lx.pos, "unterminated string"}
+	}
+
+	c := lx.src[lx.pos]
+	switch c {
+	case 'n':
+		sb.WriteByte('\n')
+	case 't':
+		sb.WriteByte('\t')
+	case 'r':
+		sb.WriteByte('\r')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'v':
+		sb.WriteByte('\v')
+	case '0':
+		sb.WriteByte(0)
+	case '\r':
+		// line continuation
+		if strings.HasPrefix(lx.src[lx.pos:], "\r\n") {
+			lx.pos++
+		}
+	case '\n':
+	case 'x':
+		if lx.pos+3 > len(lx.src) {
+			return &SyntaxError{lx.pos, "invalid hex escape"}
+		}
+		n, err := strconv.ParseUint(lx.src[lx.pos+1:lx.pos+3], 16, 8)
+		if err != nil {
+			return &SyntaxError{lx.pos, "invalid hex escape"}
+		}
+		sb.WriteRune(rune(n))
+		lx.pos += 3
+		return nil
+	case 'u':
+		code, err := lx.readUnicodeEscape()
+		if err != nil {
+			return err
+		}
+
+		// combine surrogate pairs written as two escapes
+		if code >= 0xd800 && code < 0xdc00 && strings.HasPrefix(lx.src[lx.pos:], "\\u") {
+			save := lx.pos
+			lx.pos++
+			low, err := lx.readUnicodeEscape()
+			if err == nil && low >= 0xdc00 && low < 0xe000 {
+				sb.WriteRune((code-0xd800)<<10 + (low - 0xdc00) + 0x10000)
+				return nil
+			}
+			lx.pos = save
+		}
+		sb.WriteRune(code)
+		return nil
+	default:
+		r, size := utf8.DecodeRuneInString(lx.src[lx.pos:])
+		sb.WriteRune(r)
+		lx.pos += size
+		return nil
+	}
+
+	lx.pos++
+	return nil
+}
+
+func (lx *lexer) readString(quote byte) (string, error) {
+	start := lx.pos
+	lx.pos++
+
+	var sb strings.Builder
+	for {
+		if lx.pos >= len(lx.src) {
+			return "", &SyntaxError{start, "unterminated string"}
+		}
+
+		c := lx.src[lx.pos]
+		switch {
+		case c == quote:
+			lx.pos++
+			return sb.String(), nil
+		case c == '\\':
+			lx.pos++
+			if err := lx.readEscape(&sb); err != nil {
+				return "", err
+			}
+		case c == '\n' || c == '\r':
+			return "", &SyntaxError{lx.pos, "unterminated string"}
+		default:
+			sb.WriteByte(c)
+			lx.pos++
+		}
+	}
+}
+
+// template literals are only supported without substitutions
+func (lx *lexer) readTemplate() (string, error) {
+	start := lx.pos
+	lx.pos++
+
+	var sb strings.Builder
+	for {
+		if lx.pos >= len(lx.src) {
+			return "", &SyntaxError{start, "unterminated template"}
+		}
+
+		c := lx.src[lx.pos]
+		switch {
+		case c == '`':
+			lx.pos++
+			return sb.String(), nil
+		case c == '\\':
+			lx.pos++
+			if err := lx.readEscape(&sb); err != nil {
+				return "", err
+			}
+		case c == '$' && strings.HasPrefix(lx.src[lx.pos:], "${"):
+			return "", &SyntaxError{lx.pos, "template substitutions are not supported"}
+		default:
+			sb.WriteByte(c)
+			lx.pos++
+		}
+	}
+}
+
+func (lx *lexer) readRegex() (string, string, error) {
+	start := lx.pos
+	lx.pos++
+
+	inClass := false
+	for {
+		if lx.pos >= len(lx.src) || lx.src[lx.pos] == '\n' {
+			return "", "", &SyntaxError{start, "unterminated regex"}
+		}
+
+		c := lx.src[lx.pos]
+		if c == '\\' {
+			lx.pos += 2
+			continue
+		}
+		if c == '[' {
+			inClass = true
+		} else if c == ']' {
+			inClass = false
+		} else if c == '/' && !inClass {
+			break
+		}
+		lx.pos++
+	}
+
+	pattern := lx.src[start+1 : lx.pos]
+	lx.pos++
+
+	flagStart := lx.pos
+	for lx.pos < len(lx.src) && lx.src[lx.pos] >= 'a' && lx.src[lx.pos] <= 'z' {
+		lx.pos++
+	}
+
+	return pattern, lx.src[flagStart:lx.pos], nil
+}
+
+// used by the parser to tell integers apart in property keys
+func isIntegral(f float64) bool {
+	return f == math.Trunc(f) && !math.IsInf(f, 0)
+}
@@ -0,0 +1,1345 @@
+package jsinterp
+
+import (
+	"fmt"
+)
+
+// expressions
+
+type expr interface{}
+
+type (
+	numLit   struct{ value float64 }
+	strLit   struct{ value string }
+	boolLit  struct{ value bool }
+	nullLit  struct{}
+	thisExpr struct{}
+	ident    struct{ name string }
+
+	regexLit struct {
+		pattern string
+		flags   string
+	}
+
+	arrayLit struct {
+		// nil elements are holes
+		elems []expr
+	}
+
+	property struct {
+		key      string
+		computed expr
+		value    expr
+		spread   bool
+	}
+
+	objectLit struct {
+		props []property
+	}
+
+	funcLit struct {
+		name   string
+		params []param
+		body   []stmt
+		arrow  bool
+		source string
+
+		// body is a single expression for "x => x + 1"
+		exprBody expr
+
+		// var declared names and function declarations, hoisted on call
+		vars  []string
+		funcs []*funcLit
+	}
+
+	param struct {
+		name       string
+		defaultVal expr
+		rest       bool
+	}
+
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+
+	updateExpr struct {
+		op     string
+		prefix bool
+		x      expr
+	}
+
+	binaryExpr struct {
+		op   string
+		x, y expr
+	}
+
+	logicalExpr struct {
+		op   string
+		x, y expr
+	}
+
+	assignExpr struct {
+		op     string
+		target expr
+		value  expr
+	}
+
+	condExpr struct {
+		test, cons, alt expr
+	}
+
+	callExpr struct {
+		callee   expr
+		args     []expr
+		optional bool
+	}
+
+	newExpr struct {
+		callee expr
+		args   []expr
+	}
+
+	memberExpr struct {
+		obj      expr
+		prop     string
+		computed expr
+		optional bool
+	}
+
+	seqExpr struct {
+		list []expr
+	}
+
+	spreadExpr struct {
+		x expr
+	}
+)
+
+// statements
+
+type stmt interface{}
+
+type (
+	varDecl struct {
+		kind  string
+		names []string
+		inits []expr
+	}
+
+	funcDecl struct {
+		fn *funcLit
+	}
+
+	exprStmt struct {
+		x expr
+	}
+
+	blockStmt struct {
+		body []stmt
+	}
+
+	ifStmt struct {
+		test      expr
+		cons, alt stmt
+	}
+
+	forStmt struct {
+		init   stmt
+		test   expr
+		update expr
+		body   stmt
+	}
+
+	forInStmt struct {
+		decl   string // declaration kind, empty when assigning an existing target
+		target expr
+		obj    expr
+		body   stmt
+		of     bool
+	}
+
+	whileStmt struct {
+		test expr
+		body stmt
+	}
+
+	doWhileStmt struct {
+		body stmt
+		test expr
+	}
+
+	returnStmt struct {
+		x expr
+	}
+
+	breakStmt struct {
+		label string
+	}
+
+	continueStmt struct {
+		label string
+	}
+
+	throwStmt struct {
+		x expr
+	}
+
+	tryStmt struct {
+		block     *blockStmt
+		param     string
+		handler   *blockStmt
+		finalizer *blockStmt
+	}
+
+	switchCase struct {
+		test expr // nil for default
+		body []stmt
+	}
+
+	switchStmt struct {
+		disc  expr
+		cases []switchCase
+	}
+
+	labeledStmt struct {
+		label string
+		body  stmt
+	}
+
+	emptyStmt struct{}
+)
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+
+	// innermost function being parsed, collects hoisted declarations
+	fn *funcLit
+}
+
+// parse parses a whole program, returned as the body of a function literal
+// so that hoisting works the same way for both.
+func parse(src string) (*funcLit, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{src: src, tokens: tokens}
+	program := &funcLit{name: "<program>", source: src}
+	p.fn = program
+
+	for p.peek().kind != tokEOF {
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		program.body = append(program.body, s)
+	}
+
+	return program, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *parser) advance() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) is(value string) bool {
+	tok := p.peek()
+	return tok.kind == tokPunct && tok.value == value
+}
+
+func (p *parser) isKeyword(value string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && tok.value == value
+}
+
+func (p *parser) accept(value string) bool {
+	if p.is(value) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{p.peek().pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(value string) error {
+	if !p.accept(value) {
+		return p.errorf("expected %q", value)
+	}
+	return nil
+}
+
+func (p *parser) identifier() (string, error) {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		return "", p.errorf("expected identifier")
+	}
+	p.advance()
+	return tok.value, nil
+}
+
+// statement terminator, allowing automatic semicolon insertion
+func (p *parser) semicolon() error {
+	if p.accept(";") {
+		return nil
+	}
+
+	tok := p.peek()
+	if tok.kind == tokEOF || tok.newline || p.is("}") {
+		return nil
+	}
+	return p.errorf("expected \";\"")
+}
+
+func (p *parser) statement() (stmt, error) {
+	tok := p.peek()
+
+	if tok.kind == tokPunct {
+		switch tok.value {
+		case "{":
+			return p.block()
+		case ";":
+			p.advance()
+			return &emptyStmt{}, nil
+		}
+	}
+
+	if tok.kind == tokIdent {
+		// labels
+		if next := p.peekAt(1); next.kind == tokPunct && next.value == ":" && !reserved[tok.value] {
+			p.pos += 2
+			body, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			return &labeledStmt{tok.value, body}, nil
+		}
+
+		switch tok.value {
+		case "var", "let", "const":
+			decl, err := p.varDecl()
+			if err != nil {
+				return nil, err
+			}
+			return decl, p.semicolon()
+
+		case "function":
+			p.advance()
+			fn, err := p.function(tok.pos, false)
+			if err != nil {
+				return nil, err
+			}
+			if fn.name == "" {
+				return nil, p.errorf("function declaration needs a name")
+			}
+			p.fn.funcs = append(p.fn.funcs, fn)
+			return &funcDecl{fn}, nil
+
+		case "if":
+			return p.ifStatement()
+		case "for":
+			return p.forStatement()
+
+		case "while":
+			p.advance()
+			test, err := p.parenExpr()
+			if err != nil {
+				return nil, err
+			}
+			body, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			return &whileStmt{test, body}, nil
+
+		case "do":
+			p.advance()
+			body, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isKeyword("while") {
+				return nil, p.errorf("expected while")
+			}
+			p.advance()
+			test, err := p.parenExpr()
+			if err != nil {
+				return nil, err
+			}
+			p.accept(";")
+			return &doWhileStmt{body, test}, nil
+
+		case "return":
+			p.advance()
+			ret := &returnStmt{}
+			next := p.peek()
+			if !next.newline && !p.is(";") && !p.is("}") && next.kind != tokEOF {
+				x, err := p.expression()
+				if err != nil {
+					return nil, err
+				}
+				ret.x = x
+			}
+			return ret, p.semicolon()
+
+		case "break", "continue":
+			p.advance()
+			label := ""
+			if next := p.peek(); next.kind == tokIdent && !next.newline && !reserved[next.value] {
+				label = next.value
+				p.advance()
+			}
+			if tok.value == "break" {
+				return &breakStmt{label}, p.semicolon()
+			}
+			return &continueStmt{label}, p.semicolon()
+
+		case "throw":
+			p.advance()
+			x, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return &throwStmt{x}, p.semicolon()
+
+		case "try":
+			return p.tryStatement()
+		case "switch":
+			return p.switchStatement()
+		}
+	}
+
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return &exprStmt{x}, p.semicolon()
+}
+
+func (p *parser) block() (*blockStmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	b := &blockStmt{}
+	for !p.is("}") {
+		if p.peek().kind == tokEOF {
+			return nil, p.errorf("unterminated block")
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		b.body = append(b.body, s)
+	}
+	p.advance()
+
+	return b, nil
+}
+
+func (p *parser) parenExpr() (expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return x, p.expect(")")
+}
+
+// var, let or const declarations without the terminating semicolon
+func (p *parser) varDecl() (*varDecl, error) {
+	decl := &varDecl{kind: p.advance().value}
+
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+
+		var init expr
+		if p.accept("=") {
+			init, err = p.assignment(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		decl.names = append(decl.names, name)
+		decl.inits = append(decl.inits, init)
+		if decl.kind == "var" {
+			p.fn.vars = append(p.fn.vars, name)
+		}
+
+		if !p.accept(",") {
+			return decl, nil
+		}
+	}
+}
+
+func (p *parser) ifStatement() (stmt, error) {
+	p.advance()
+	test, err := p.parenExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	cons, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ifStmt{test: test, cons: cons}
+	if p.isKeyword("else") {
+		p.advance()
+		s.alt, err = p.statement()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) forStatement() (stmt, error) {
+	p.advance()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var init stmt
+	if p.isKeyword("var") || p.isKeyword("let") || p.isKeyword("const") {
+		// for (var k in obj) / for (const v of list)
+		if next := p.peekAt(2); p.peekAt(1).kind == tokIdent && next.kind == tokIdent && (next.value == "in" || next.value == "of") {
+			kind := p.advance().value
+			name := p.advance().value
+			if kind == "var" {
+				p.fn.vars = append(p.fn.vars, name)
+			}
+			return p.forInRest(kind, &ident{name})
+		}
+
+		decl, err := p.varDecl()
+		if err != nil {
+			return nil, err
+		}
+		init = decl
+	} else if !p.is(";") {
+		x, err := p.binary(0, false)
+		if err != nil {
+			return nil, err
+		}
+		if p.isKeyword("in") || p.isKeyword("of") {
+			return p.forInRest("", x)
+		}
+
+		// finish the expression the "in" free parse stopped at
+		x, err = p.continueExpression(x)
+		if err != nil {
+			return nil, err
+		}
+		init = &exprStmt{x}
+	}
+
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+
+	s := &forStmt{init: init}
+	var err error
+	if !p.is(";") {
+		if s.test, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	if !p.is(")") {
+		if s.update, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	s.body, err = p.statement()
+	return s, err
+}
+
+func (p *parser) forInRest(decl string, target expr) (stmt, error) {
+	of := p.advance().value == "of"
+	obj, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	body, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	return &forInStmt{decl: decl, target: target, obj: obj, body: body, of: of}, nil
+}
+
+func (p *parser) tryStatement() (stmt, error) {
+	p.advance()
+	block, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &tryStmt{block: block}
+	if p.isKeyword("catch") {
+		p.advance()
+		if p.accept("(") {
+			if s.param, err = p.identifier(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		}
+		if s.handler, err = p.block(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isKeyword("finally") {
+		p.advance()
+		if s.finalizer, err = p.block(); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.handler == nil && s.finalizer == nil {
+		return nil, p.errorf("try without catch or finally")
+	}
+	return s, nil
+}
+
+func (p *parser) switchStatement() (stmt, error) {
+	p.advance()
+	disc, err := p.parenExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	s := &switchStmt{disc: disc}
+	for !p.accept("}") {
+		var c switchCase
+		if p.isKeyword("case") {
+			p.advance()
+			if c.test, err = p.expression(); err != nil {
+				return nil, err
+			}
+		} else if p.isKeyword("default") {
+			p.advance()
+		} else {
+			return nil, p.errorf("expected case or default")
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+
+		for !p.isKeyword("case") && !p.isKeyword("default") && !p.is("}") {
+			if p.peek().kind == tokEOF {
+				return nil, p.errorf("unterminated switch")
+			}
+			body, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			c.body = append(c.body, body)
+		}
+		s.cases = append(s.cases, c)
+	}
+
+	return s, nil
+}
+
+// function parameters and body, after the "function" keyword
+func (p *parser) function(start int, isExpr bool) (*funcLit, error) {
+	fn := &funcLit{}
+	if p.peek().kind == tokIdent {
+		fn.name = p.advance().value
+	}
+
+	params, err := p.params()
+	if err != nil {
+		return nil, err
+	}
+	fn.params = params
+
+	if err := p.functionBody(fn); err != nil {
+		return nil, err
+	}
+	fn.source = p.src[start:p.tokens[p.pos-1].end]
+	return fn, nil
+}
+
+func (p *parser) params() ([]param, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var params []param
+	for !p.accept(")") {
+		var prm param
+		if p.accept("...") {
+			prm.rest = true
+		}
+
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		prm.name = name
+
+		if p.accept("=") {
+			if prm.defaultVal, err = p.assignment(true); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, prm)
+
+		if !p.is(")") {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return params, nil
+}
+
+func (p *parser) functionBody(fn *funcLit) error {
+	outer := p.fn
+	p.fn = fn
+	defer func() { p.fn = outer }()
+
+	body, err := p.block()
+	if err != nil {
+		return err
+	}
+	fn.body = body.body
+	return nil
+}
+
+// whether the tokens at the current position start an arrow function
+func (p *parser) arrowAhead() bool {
+	tok := p.peek()
+	if tok.kind == tokIdent {
+		next := p.peekAt(1)
+		return next.kind == tokPunct && next.value == "=>" && !next.newline
+	}
+	if !p.is("(") {
+		return false
+	}
+
+	depth := 0
+	for i := p.pos; i < len(p.tokens); i++ {
+		t := p.tokens[i]
+		if t.kind == tokEOF {
+			return false
+		}
+		if t.kind != tokPunct {
+			continue
+		}
+		switch t.value {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+			if depth == 0 {
+				next := p.tokens[i+1]
+				return next.kind == tokPunct && next.value == "=>"
+			}
+		}
+	}
+	return false
+}
+
+func (p *parser) arrowFunction() (expr, error) {
+	start := p.peek().pos
+	fn := &funcLit{arrow: true}
+
+	if p.peek().kind == tokIdent {
+		fn.params = []param{{name: p.advance().value}}
+	} else {
+		params, err := p.params()
+		if err != nil {
+			return nil, err
+		}
+		fn.params = params
+	}
+
+	if err := p.expect("=>"); err != nil {
+		return nil, err
+	}
+
+	if p.is("{") {
+		if err := p.functionBody(fn); err != nil {
+			return nil, err
+		}
+	} else {
+		outer := p.fn
+		p.fn = fn
+		body, err := p.assignment(true)
+		p.fn = outer
+		if err != nil {
+			return nil, err
+		}
+		fn.exprBody = body
+	}
+
+	fn.source = p.src[start:p.tokens[p.pos-1].end]
+	return fn, nil
+}
+
+func (p *parser) expression() (expr, error) {
+	x, err := p.assignment(true)
+	if err != nil {
+		return nil, err
+	}
+	return p.sequenceRest(x, true)
+}
+
+// continue a comma sequence after its first element
+func (p *parser) sequenceRest(x expr, allowIn bool) (expr, error) {
+	if !p.is(",") {
+		return x, nil
+	}
+
+	seq := &seqExpr{list: []expr{x}}
+	for p.accept(",") {
+		next, err := p.assignment(allowIn)
+		if err != nil {
+			return nil, err
+		}
+		seq.list = append(seq.list, next)
+	}
+	return seq, nil
+}
+
+// finish an expression whose leading binary part has already been parsed
+func (p *parser) continueExpression(x expr) (expr, error) {
+	x, err := p.binaryRest(x, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	x, err = p.assignmentRest(x, true)
+	if err != nil {
+		return nil, err
+	}
+	return p.sequenceRest(x, true)
+}
+
+var assignOps = map[string]bool{
+	"=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true,
+	"**=": true, "<<=": true, ">>=": true, ">>>=": true, "&=": true, "|=": true,
+	"^=": true, "&&=": true, "||=": true, "??=": true,
+}
+
+func (p *parser) assignment(allowIn bool) (expr, error) {
+	if p.arrowAhead() {
+		return p.arrowFunction()
+	}
+
+	x, err := p.binary(0, allowIn)
+	if err != nil {
+		return nil, err
+	}
+	return p.assignmentRest(x, allowIn)
+}
+
+// conditional and assignment operators following a binary expression
+func (p *parser) assignmentRest(x expr, allowIn bool) (expr, error) {
+	if p.accept("?") {
+		cons, err := p.assignment(true)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		alt, err := p.assignment(allowIn)
+		if err != nil {
+			return nil, err
+		}
+		return &condExpr{x, cons, alt}, nil
+	}
+
+	tok := p.peek()
+	if tok.kind == tokPunct && assignOps[tok.value] {
+		switch x.(type) {
+		case *ident, *memberExpr:
+		default:
+			return nil, p.errorf("invalid assignment target")
+		}
+
+		p.advance()
+		value, err := p.assignment(allowIn)
+		if err != nil {
+			return nil, err
+		}
+		return &assignExpr{tok.value, x, value}, nil
+	}
+
+	return x, nil
+}
+
+var binaryPrecedence = map[string]int{
+	"??": 1,
+	"||": 2,
+	"&&": 3,
+	"|":  4,
+	"^":  5,
+	"&":  6,
+	"==": 7, "!=": 7, "===": 7, "!==": 7,
+	"<": 8, ">": 8, "<=": 8, ">=": 8, "instanceof": 8, "in": 8,
+	"<<": 9, ">>": 9, ">>>": 9,
+	"+": 10, "-": 10,
+	"*": 11, "/": 11, "%": 11,
+	"**": 12,
+}
+
+// binary operator at the current position and its precedence
+func (p *parser) binaryOp(allowIn bool) (string, int) {
+	tok := p.peek()
+	if tok.kind == tokPunct || tok.kind == tokIdent && (tok.value == "instanceof" || tok.value == "in" && allowIn) {
+		if prec, ok := binaryPrecedence[tok.value]; ok {
+			return tok.value, prec
+		}
+	}
+	return "", 0
+}
+
+func (p *parser) binary(minPrec int, allowIn bool) (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return p.binaryRest(x, minPrec, allowIn)
+}
+
+func (p *parser) binaryRest(x expr, minPrec int, allowIn bool) (expr, error) {
+	for {
+		op, prec := p.binaryOp(allowIn)
+		if prec == 0 || prec <= minPrec {
+			return x, nil
+		}
+		p.advance()
+
+		// exponentiation is right associative
+		nextMin := prec
+		if op == "**" {
+			nextMin = prec - 1
+		}
+
+		y, err := p.binary(nextMin, allowIn)
+		if err != nil {
+			return nil, err
+		}
+
+		if op == "&&" || op == "||" || op == "??" {
+			x = &logicalExpr{op, x, y}
+		} else {
+			x = &binaryExpr{op, x, y}
+		}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	tok := p.peek()
+
+	if tok.kind == tokPunct {
+		switch tok.value {
+		case "!", "-", "+", "~":
+			p.advance()
+			x, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryExpr{tok.value, x}, nil
+		case "++", "--":
+			p.advance()
+			x, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &updateExpr{tok.value, true, x}, nil
+		}
+	}
+
+	if tok.kind == tokIdent {
+		switch tok.value {
+		case "typeof", "void", "delete":
+			p.advance()
+			x, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryExpr{tok.value, x}, nil
+		}
+	}
+
+	x, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+
+	next := p.peek()
+	if next.kind == tokPunct && (next.value == "++" || next.value == "--") && !next.newline {
+		p.advance()
+		return &updateExpr{next.value, false, x}, nil
+	}
+	return x, nil
+}
+
+// member access and calls following a primary expression
+func (p *parser) postfix() (expr, error) {
+	var x expr
+	var err error
+
+	if p.isKeyword("new") {
+		x, err = p.newExpression()
+	} else {
+		x, err = p.primary()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			name, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			x = &memberExpr{obj: x, prop: name}
+
+		case p.accept("?."):
+			if p.is("(") {
+				args, err := p.arguments()
+				if err != nil {
+					return nil, err
+				}
+				x = &callExpr{callee: x, args: args, optional: true}
+			} else if p.accept("[") {
+				prop, err := p.expression()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect("]"); err != nil {
+					return nil, err
+				}
+				x = &memberExpr{obj: x, computed: prop, optional: true}
+			} else {
+				name, err := p.identifier()
+				if err != nil {
+					return nil, err
+				}
+				x = &memberExpr{obj: x, prop: name, optional: true}
+			}
+
+		case p.accept("["):
+			prop, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &memberExpr{obj: x, computed: prop}
+
+		case p.is("("):
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			x = &callExpr{callee: x, args: args}
+
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) newExpression() (expr, error) {
+	p.advance()
+
+	var callee expr
+	var err error
+	if p.isKeyword("new") {
+		callee, err = p.newExpression()
+	} else {
+		callee, err = p.primary()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// member access binds tighter than new, calls don't
+	for {
+		if p.accept(".") {
+			name, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			callee = &memberExpr{obj: callee, prop: name}
+		} else if p.accept("[") {
+			prop, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			callee = &memberExpr{obj: callee, computed: prop}
+		} else {
+			break
+		}
+	}
+
+	var args []expr
+	if p.is("(") {
+		if args, err = p.arguments(); err != nil {
+			return nil, err
+		}
+	}
+	return &newExpr{callee, args}, nil
+}
+
+func (p *parser) arguments() ([]expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var args []expr
+	for !p.accept(")") {
+		var arg expr
+		var err error
+		if p.accept("...") {
+			x, err := p.assignment(true)
+			if err != nil {
+				return nil, err
+			}
+			arg = &spreadExpr{x}
+		} else if arg, err = p.assignment(true); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if !p.is(")") {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return args, nil
+}
+
+// words that can't be used as plain identifiers
+var reserved = map[string]bool{
+	"break": true, "case": true, "catch": true, "const": true, "continue": true,
+	"default": true, "delete": true, "do": true, "else": true, "finally": true,
+	"for": true, "function": true, "if": true, "in": true, "instanceof": true,
+	"new": true, "return": true, "switch": true, "throw": true, "try": true,
+	"typeof": true, "var": true, "void": true, "while": true, "let": true,
+}
+
+func (p *parser) primary() (expr, error) {
+	tok := p.peek()
+
+	switch tok.kind {
+	case tokNum:
+		p.advance()
+		return &numLit{tok.num}, nil
+	case tokStr, tokTemplate:
+		p.advance()
+		return &strLit{tok.value}, nil
+	case tokRegex:
+		p.advance()
+		return &regexLit{tok.value, tok.flags}, nil
+
+	case tokIdent:
+		switch tok.value {
+		case "function":
+			p.advance()
+			return p.function(tok.pos, true)
+		case "this":
+			p.advance()
+			return &thisExpr{}, nil
+		case "null":
+			p.advance()
+			return &nullLit{}, nil
+		case "true", "false":
+			p.advance()
+			return &boolLit{tok.value == "true"}, nil
+		}
+
+		if reserved[tok.value] {
+			return nil, p.errorf("unexpected %q", tok.value)
+		}
+		p.advance()
+		return &ident{tok.value}, nil
+
+	case tokPunct:
+		switch tok.value {
+		case "(":
+			p.advance()
+			x, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			return p.arrayLiteral()
+		case "{":
+			return p.objectLiteral()
+		}
+	}
+
+	if tok.kind == tokEOF {
+		return nil, p.errorf("unexpected end of input")
+	}
+	return nil, p.errorf("unexpected token %q", p.src[tok.pos:tok.end])
+}
+
+func (p *parser) arrayLiteral() (expr, error) {
+	p.advance()
+
+	arr := &arrayLit{}
+	for !p.accept("]") {
+		if p.is(",") {
+			p.advance()
+			arr.elems = append(arr.elems, nil)
+			continue
+		}
+
+		var elem expr
+		var err error
+		if p.accept("...") {
+			x, err := p.assignment(true)
+			if err != nil {
+				return nil, err
+			}
+			elem = &spreadExpr{x}
+		} else if elem, err = p.assignment(true); err != nil {
+			return nil, err
+		}
+		arr.elems = append(arr.elems, elem)
+
+		if !p.is("]") {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return arr, nil
+}
+
+func (p *parser) objectLiteral() (expr, error) {
+	p.advance()
+
+	obj := &objectLit{}
+	for !p.accept("}") {
+		var prop property
+		tok := p.peek()
+
+		switch {
+		case p.accept("..."):
+			x, err := p.assignment(true)
+			if err != nil {
+				return nil, err
+			}
+			prop.spread = true
+			prop.value = x
+
+		case p.accept("["):
+			key, err := p.assignment(true)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			prop.computed = key
+
+		case tok.kind == tokIdent || tok.kind == tokStr:
+			p.advance()
+			prop.key = tok.value
+		case tok.kind == tokNum:
+			p.advance()
+			prop.key = numberToString(tok.num)
+		default:
+			return nil, p.errorf("invalid object key")
+		}
+
+		if !prop.spread {
+			switch {
+			case p.accept(":"):
+				value, err := p.assignment(true)
+				if err != nil {
+					return nil, err
+				}
+				prop.value = value
+
+			case p.is("("):
+				// method shorthand
+				fn := &funcLit{name: prop.key}
+				params, err := p.params()
+				if err != nil {
+					return nil, err
+				}
+				fn.params = params
+				if err := p.functionBody(fn); err != nil {
+					return nil, err
+				}
+				fn.source = p.src[tok.pos:p.tokens[p.pos-1].end]
+				prop.value = fn
+
+			case tok.kind == tokIdent && prop.computed == nil:
+				// shorthand {a} for {a: a}
+				prop.value = &ident{tok.value}
+
+			default:
+				return nil, p.errorf("expected \":\"")
+			}
+		}
+		obj.props = append(obj.props, prop)
+
+		if !p.is("}") {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return obj, nil
+}
@@ -0,0 +1,628 @@
+package jsinterp
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Value is a JavaScript value: Undefined, Null, bool, float64, string,
+// *Object, *Array, *Function or *RegExp.
+type Value interface{}
+
+type undefinedType struct{}
+type nullType struct{}
+
+var (
+	Undefined Value = undefinedType{}
+	Null      Value = nullType{}
+)
+
+// Object is a plain JavaScript object.
+type Object struct {
+	props map[string]Value
+	keys  []string
+
+	// class name reported by Object.prototype.toString, e.g. "Error"
+	class string
+}
+
+func newObject() *Object {
+	return &Object{props: map[string]Value{}, class: "Object"}
+}
+
+func (o *Object) get(key string) (Value, bool) {
+	v, ok := o.props[key]
+	return v, ok
+}
+
+func (o *Object) set(key string, v Value) {
+	if _, ok := o.props[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.props[key] = v
+}
+
+func (o *Object) delete(key string) {
+	if _, ok := o.props[key]; !ok {
+		return
+	}
+	delete(o.props, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Array is a JavaScript array, holes are stored as Undefined.
+type Array struct {
+	elems []Value
+	props *Object
+}
+
+func newArray(elems []Value) *Array {
+	return &Array{elems: elems}
+}
+
+// Function is a closure over interpreted code or a native builtin.
+type Function struct {
+	name   string
+	lit    *funcLit
+	env    *scope
+	native func(vm *VM, this Value, args []Value) (Value, error)
+
+	// constructor used by new for native functions
+	construct func(vm *VM, args []Value) (Value, error)
+
+	props *Object
+}
+
+func (f *Function) properties() *Object {
+	if f.props == nil {
+		f.props = newObject()
+	}
+	return f.props
+}
+
+// RegExp is a regular expression, translated to Go's RE2 syntax.
+type RegExp struct {
+	source    string
+	flags     string
+	rx        *regexp.Regexp
+	lastIndex int
+}
+
+func (r *RegExp) global() bool {
+	return strings.Contains(r.flags, "g")
+}
+
+// translate a JavaScript regex into RE2 syntax
+func compileRegExp(source string, flags string) (*RegExp, error) {
+	pattern := source
+	// \d, \w and \s mean the same, "/" needs no escaping
+	pattern = strings.ReplaceAll(pattern, `\/`, `/`)
+	// RE2 has no \cX, \uXXXX or \xXX forms with these semantics
+	pattern = regexp.MustCompile(`\\u([0-9a-fA-F]{4})`).ReplaceAllString(pattern, `\x{$1}`)
+
+	prefix := ""
+	if strings.Contains(flags, "i") {
+		prefix += "i"
+	}
+	if strings.Contains(flags, "m") {
+		prefix += "m"
+	}
+	if strings.Contains(flags, "s") {
+		prefix += "s"
+	}
+	if prefix != "" {
+		pattern = "(?" + prefix + ")" + pattern
+	}
+
+	rx, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &RegExp{source: source, flags: flags, rx: rx}, nil
+}
+
+// JavaScript strings are UTF-16, Go strings are UTF-8. Most strings handled
+// here are ASCII, where both agree, so only convert when needed.
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func toUTF16(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+func fromUTF16(u []uint16) string {
+	return string(utf16.Decode(u))
+}
+
+func strLen(s string) int {
+	if isASCII(s) {
+		return len(s)
+	}
+	return len(toUTF16(s))
+}
+
+// code unit at index i, -1 when out of range
+func strCodeAt(s string, i int) int {
+	if isASCII(s) {
+		if i < 0 || i >= len(s) {
+			return -1
+		}
+		return int(s[i])
+	}
+
+	u := toUTF16(s)
+	if i < 0 || i >= len(u) {
+		return -1
+	}
+	return int(u[i])
+}
+
+// substring by code unit indices, which must already be clamped
+func strSlice(s string, start int, end int) string {
+	if start >= end {
+		return ""
+	}
+	if isASCII(s) {
+		return s[start:end]
+	}
+	return fromUTF16(toUTF16(s)[start:end])
+}
+
+// index of sub in s counted in code units, starting at from
+func strIndex(s string, sub string, from int) int {
+	if isASCII(s) && isASCII(sub) {
+		if from > len(s) {
+			from = len(s)
+		}
+		i := strings.Index(s[from:], sub)
+		if i < 0 {
+			return -1
+		}
+		return i + from
+	}
+
+	u, su := toUTF16(s), toUTF16(sub)
+	for i := from; i+len(su) <= len(u); i++ {
+		match := true
+		for j := range su {
+			if u[i+j] != su[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// conversions
+
+func typeOf(v Value) string {
+	switch v.(type) {
+	case undefinedType:
+		return "undefined"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Function:
+		return "function"
+	}
+	return "object"
+}
+
+func toBool(v Value) bool {
+	switch v := v.(type) {
+	case undefinedType, nullType:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func toNumber(v Value) float64 {
+	switch v := v.(type) {
+	case undefinedType:
+		return math.NaN()
+	case nullType:
+		return 0
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case float64:
+		return v
+	case string:
+		return stringToNumber(v)
+	case *Array:
+		return stringToNumber(toString(v))
+	}
+	return math.NaN()
+}
+
+func stringToNumber(s string) float64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+
+	if len(s) > 2 && s[0] == '0' {
+		base := 0
+		switch s[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 0 {
+			n, err := strconv.ParseUint(s[2:], base, 64)
+			if err != nil {
+				return math.NaN()
+			}
+			return float64(n)
+		}
+	}
+
+	switch s {
+	case "Infinity", "+Infinity":
+		return math.Inf(1)
+	case "-Infinity":
+		return math.Inf(-1)
+	}
+
+	// ParseFloat accepts forms JavaScript doesn't
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c == '.' || c == 'e' || c == 'E' || c == '+' || c == '-') {
+			return math.NaN()
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return n
+}
+
+func numberToString(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		return "0"
+	case isIntegral(f) && math.Abs(f) < 1e21:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	abs := math.Abs(f)
+	if abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	// exponent form, JavaScript writes 1e+21 rather than 1e+21 with padding
+	str := strconv.FormatFloat(f, 'e', -1, 64)
+	mantissa, exp := str[:strings.IndexByte(str, 'e')], str[strings.IndexByte(str, 'e')+1:]
+	sign := exp[0]
+	exp = strings.TrimLeft(exp[1:], "0")
+	return mantissa + "e" + string(sign) + exp
+}
+
+// number to string in another base, as Number.prototype.toString(radix)
+func numberToRadix(f float64, radix int) string {
+	if radix == 10 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return numberToString(f)
+	}
+	if isIntegral(f) && math.Abs(f) < 1<<53 {
+		return strconv.FormatInt(int64(f), radix)
+	}
+
+	// fractional part, to a limited precision
+	neg := f < 0
+	f = math.Abs(f)
+	intPart := math.Floor(f)
+	frac := f - intPart
+
+	var sb strings.Builder
+	if neg {
+		sb.WriteByte('-')
+	}
+	sb.WriteString(strconv.FormatInt(int64(intPart), radix))
+	sb.WriteByte('.')
+	for i := 0; i < 20 && frac > 0; i++ {
+		frac *= float64(radix)
+		digit := int(frac)
+		sb.WriteString(strconv.FormatInt(int64(digit), radix))
+		frac -= float64(digit)
+	}
+	return sb.String()
+}
+
+// deepest arrays may nest inside each other when converted to a string
+const maxNesting = 1000
+
+var (
+	errNesting = errors.New("array nesting too deep")
+	errLength  = errors.New("invalid string length")
+)
+
+// join the elements of arr as strings. joining holds the arrays being joined
+// further up, which are rendered as "" the way engines break cycles
+func joinArray(arr *Array, sep string, joining []*Array) (string, error) {
+	for _, outer := range joining {
+		if outer == arr {
+			return "", nil
+		}
+	}
+	if len(joining) >= maxNesting {
+		return "", errNesting
+	}
+	joining = append(joining, arr)
+
+	var sb strings.Builder
+	for i, elem := range arr.elems {
+		if i > 0 {
+			sb.WriteString(sep)
+		}
+
+		var part string
+		switch elem := elem.(type) {
+		case undefinedType, nullType:
+		case *Array:
+			var err error
+			if part, err = joinArray(elem, ",", joining); err != nil {
+				return "", err
+			}
+		default:
+			part = toString(elem)
+		}
+
+		// shared elements can grow the result exponentially without a cycle
+		if sb.Len()+len(part) > maxLength {
+			return "", errLength
+		}
+		sb.WriteString(part)
+	}
+	return sb.String(), nil
+}
+
+func toString(v Value) string {
+	switch v := v.(type) {
+	case undefinedType:
+		return "undefined"
+	case nullType:
+		return "null"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return numberToString(v)
+	case string:
+		return v
+	case *Array:
+		// arrays too deep or too long to join come out empty here, the
+		// callers that can throw use joinArray directly
+		s, _ := joinArray(v, ",", nil)
+		return s
+	case *Function:
+		if v.lit != nil {
+			return v.lit.source
+		}
+		return "function " + v.name + "() { [native code] }"
+	case *RegExp:
+		return "/" + v.source + "/" + v.flags
+	case *Object:
+		if v.class == "Error" {
+			name, message := "Error", ""
+			if n, ok := v.get("name"); ok {
+				name = toString(n)
+			}
+			if m, ok := v.get("message"); ok {
+				message = toString(m)
+			}
+			if message == "" {
+				return name
+			}
+			return name + ": " + message
+		}
+		return "[object " + v.class + "]"
+	}
+	return ""
+}
+
+// ToPrimitive for the + operator and comparisons
+func toPrimitive(v Value) Value {
+	switch v.(type) {
+	case *Array, *Function, *RegExp:
+		return toString(v)
+	case *Object:
+		obj := v.(*Object)
+		if t, ok := obj.get("__time__"); ok && obj.class == "Date" {
+			return t
+		}
+		return toString(v)
+	}
+	return v
+}
+
+// ToInt32 for the bitwise operators
+func toInt32(v Value) int32 {
+	f := toNumber(v)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return int32(uint32(int64(math.Mod(math.Trunc(f), 1<<32))))
+}
+
+func toUint32(v Value) uint32 {
+	return uint32(toInt32(v))
+}
+
+// integer for indices and counts, NaN becoming 0
+func toInteger(v Value) float64 {
+	f := toNumber(v)
+	if math.IsNaN(f) {
+		return 0
+	}
+	return math.Trunc(f)
+}
+
+// property key for a value, numbers in canonical form
+func toPropertyKey(v Value) string {
+	return toString(v)
+}
+
+// array index for a key, -1 when it isn't one
+func arrayIndex(key Value) int {
+	switch k := key.(type) {
+	case float64:
+		if k >= 0 && isIntegral(k) && k < 1<<32-1 {
+			return int(k)
+		}
+		return -1
+	case string:
+		if k == "" || len(k) > 10 || (len(k) > 1 && k[0] == '0') {
+			return -1
+		}
+		n, err := strconv.Atoi(k)
+		if err != nil || n < 0 {
+			return -1
+		}
+		return n
+	}
+	return -1
+}
+
+func strictEquals(a Value, b Value) bool {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		return ok && a == b
+	case string:
+		b, ok := b.(string)
+		return ok && a == b
+	case bool:
+		b, ok := b.(bool)
+		return ok && a == b
+	case undefinedType:
+		_, ok := b.(undefinedType)
+		return ok
+	case nullType:
+		_, ok := b.(nullType)
+		return ok
+	}
+	return a == b
+}
+
+func looseEquals(a Value, b Value) bool {
+	isNullish := func(v Value) bool {
+		switch v.(type) {
+		case undefinedType, nullType:
+			return true
+		}
+		return false
+	}
+
+	if isNullish(a) || isNullish(b) {
+		return isNullish(a) && isNullish(b)
+	}
+	if typeOf(a) == typeOf(b) && !(isObjectLike(a) != isObjectLike(b)) {
+		return strictEquals(a, b)
+	}
+
+	if _, ok := a.(bool); ok {
+		return looseEquals(toNumber(a), b)
+	}
+	if _, ok := b.(bool); ok {
+		return looseEquals(a, toNumber(b))
+	}
+
+	if isObjectLike(a) && !isObjectLike(b) {
+		return looseEquals(toPrimitive(a), b)
+	}
+	if isObjectLike(b) && !isObjectLike(a) {
+		return looseEquals(a, toPrimitive(b))
+	}
+
+	return toNumber(a) == toNumber(b)
+}
+
+func isObjectLike(v Value) bool {
+	switch v.(type) {
+	case *Object, *Array, *Function, *RegExp:
+		return true
+	}
+	return false
+}
+
+// own enumerable keys, array indices first in ascending order
+func ownKeys(v Value) []string {
+	var keys []string
+	switch v := v.(type) {
+	case *Object:
+		keys = append(keys, v.keys...)
+	case *Array:
+		for i := range v.elems {
+			keys = append(keys, strconv.Itoa(i))
+		}
+		if v.props != nil {
+			keys = append(keys, v.props.keys...)
+		}
+	case *Function:
+		if v.props != nil {
+			keys = append(keys, v.props.keys...)
+		}
+	case string:
+		for i := 0; i < strLen(v); i++ {
+			keys = append(keys, strconv.Itoa(i))
+		}
+	}
+	return keys
+}
+
+// sort helper used by Array.prototype.sort without a comparator
+func sortByString(elems []Value) {
+	sort.SliceStable(elems, func(i int, j int) bool {
+		_, iu := elems[i].(undefinedType)
+		_, ju := elems[j].(undefinedType)
+		if iu || ju {
+			return !iu && ju
+		}
+		return toString(elems[i]) < toString(elems[j])
+	})
+}
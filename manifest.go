@@ -0,0 +1,314 @@
+package StreamTool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrManifest is returned when a manifest can't be read.
+var ErrManifest = errors.New("invalid manifest")
+
+// ManifestFormats fetches the HLS or DASH manifest at manifestURL and lists
+// its variants. Errors are ExtractErrors, unreadable manifests also match
+// ErrManifest.
+func (c *Client) ManifestFormats(ctx context.Context, manifestURL string) ([]Format, error) {
+	resp, err := c.get(ctx, manifestURL)
+	if err != nil {
+		return nil, fetchError("manifest", StageFetch, "couldn't fetch manifest", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fetchError("manifest", StageFetch, "couldn't read manifest", err)
+	}
+
+	var formats []Format
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("#EXTM3U")):
+		formats, err = ParseHLS(bytes.NewReader(body), manifestURL)
+	case bytes.Contains(trimmed, []byte("<MPD")):
+		formats, err = ParseDASH(bytes.NewReader(body), manifestURL)
+	default:
+		err = fmt.Errorf("%w: unknown manifest type", ErrManifest)
+	}
+	if err != nil {
+		return nil, newExtractError("manifest", StageDecode, ErrLayoutChanged, "%w", err)
+	}
+
+	return formats, nil
+}
+
+// resolve ref against base, leaving it alone when either can't be parsed
+func resolveRef(base string, ref string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+
+	return baseURL.ResolveReference(refURL).String()
+}
+
+// report which kinds of media a codecs list such as "mp4a.40.2,avc1.4d401f"
+// holds
+func codecKinds(codecs string) (audio bool, video bool) {
+	for _, codec := range strings.Split(codecs, ",") {
+		codec = strings.ToLower(strings.TrimSpace(codec))
+		for _, prefix := range []string{"mp4a", "opus", "ac-3", "ec-3", "flac", "vorbis", "mp3"} {
+			if strings.HasPrefix(codec, prefix) {
+				audio = true
+			}
+		}
+		for _, prefix := range []string{"avc", "hvc", "hev", "vp8", "vp09", "vp9", "av01"} {
+			if strings.HasPrefix(codec, prefix) {
+				video = true
+			}
+		}
+	}
+	return audio, video
+}
+
+// split an attribute list such as `BANDWIDTH=1280000,CODECS="mp4a.40.2,avc1"`
+func parseHLSAttributes(list string) map[string]string {
+	attrs := map[string]string{}
+
+	for len(list) > 0 {
+		eq := strings.IndexByte(list, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(list[:eq])
+		list = list[eq+1:]
+
+		value := ""
+		if strings.HasPrefix(list, `"`) {
+			end := strings.IndexByte(list[1:], '"')
+			if end == -1 {
+				value, list = list[1:], ""
+			} else {
+				value, list = list[1:end+1], list[end+2:]
+			}
+		} else if comma := strings.IndexByte(list, ','); comma != -1 {
+			value, list = list[:comma], list[comma:]
+		} else {
+			value, list = list, ""
+		}
+
+		attrs[key] = value
+		list = strings.TrimPrefix(list, ",")
+	}
+
+	return attrs
+}
+
+// ParseHLS lists the variants and audio renditions of an m3u8 master
+// playlist, resolving their uris against base. A media playlist is returned
+// as a single format pointing at base.
+func ParseHLS(r io.Reader, base string) ([]Format, error) {
+	var formats []Format
+	var variant map[string]string
+	media := false
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			if !strings.HasPrefix(line, "#EXTM3U") {
+				return nil, fmt.Errorf("%w: missing #EXTM3U", ErrManifest)
+			}
+			first = false
+			continue
+		}
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			variant = parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+			if attrs["TYPE"] == "AUDIO" && attrs["URI"] != "" {
+				formats = append(formats, Format{
+					URL:       resolveRef(base, attrs["URI"]),
+					MimeType:  "application/vnd.apple.mpegurl",
+					Container: "m3u8",
+					HasAudio:  true,
+					Protocol:  ProtocolHLS,
+				})
+			}
+		case strings.HasPrefix(line, "#EXTINF"):
+			media = true
+		case strings.HasPrefix(line, "#"):
+		case variant != nil:
+			formats = append(formats, hlsVariantFormat(variant, resolveRef(base, line)))
+			variant = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrManifest, err)
+	}
+	if first {
+		return nil, fmt.Errorf("%w: empty playlist", ErrManifest)
+	}
+
+	if len(formats) == 0 && media {
+		formats = append(formats, Format{
+			URL:       base,
+			MimeType:  "application/vnd.apple.mpegurl",
+			Container: "m3u8",
+			HasAudio:  true,
+			Protocol:  ProtocolHLS,
+		})
+	}
+
+	return formats, nil
+}
+
+func hlsVariantFormat(attrs map[string]string, variantURL string) Format {
+	format := Format{
+		URL:       variantURL,
+		MimeType:  "application/vnd.apple.mpegurl",
+		Codec:     attrs["CODECS"],
+		Container: "m3u8",
+		Protocol:  ProtocolHLS,
+	}
+
+	bandwidth := attrs["AVERAGE-BANDWIDTH"]
+	if bandwidth == "" {
+		bandwidth = attrs["BANDWIDTH"]
+	}
+	format.Bitrate, _ = strconv.Atoi(bandwidth)
+
+	if resolution := strings.SplitN(attrs["RESOLUTION"], "x", 2); len(resolution) == 2 {
+		format.Width, _ = strconv.Atoi(resolution[0])
+		format.Height, _ = strconv.Atoi(resolution[1])
+	}
+
+	// without codecs all that's known is whether there's a picture
+	if format.Codec == "" {
+		format.HasAudio = true
+		format.HasVideo = format.Height > 0
+	} else {
+		format.HasAudio, format.HasVideo = codecKinds(format.Codec)
+	}
+
+	return format
+}
+
+type dashRepresentation struct {
+	ID                string `xml:"id,attr"`
+	MimeType          string `xml:"mimeType,attr"`
+	Codecs            string `xml:"codecs,attr"`
+	Bandwidth         int    `xml:"bandwidth,attr"`
+	Width             int    `xml:"width,attr"`
+	Height            int    `xml:"height,attr"`
+	AudioSamplingRate string `xml:"audioSamplingRate,attr"`
+	BaseURL           string `xml:"BaseURL"`
+}
+
+type dashAdaptationSet struct {
+	MimeType          string               `xml:"mimeType,attr"`
+	ContentType       string               `xml:"contentType,attr"`
+	Codecs            string               `xml:"codecs,attr"`
+	AudioSamplingRate string               `xml:"audioSamplingRate,attr"`
+	BaseURL           string               `xml:"BaseURL"`
+	Representations   []dashRepresentation `xml:"Representation"`
+}
+
+type dashPeriod struct {
+	BaseURL        string              `xml:"BaseURL"`
+	AdaptationSets []dashAdaptationSet `xml:"AdaptationSet"`
+}
+
+type dashMPD struct {
+	BaseURL string       `xml:"BaseURL"`
+	Periods []dashPeriod `xml:"Period"`
+}
+
+// ParseDASH lists the representations of an mpd manifest. Representations
+// with their own BaseURL point at it, segmented ones point at base itself.
+func ParseDASH(r io.Reader, base string) ([]Format, error) {
+	var mpd dashMPD
+	if err := xml.NewDecoder(r).Decode(&mpd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrManifest, err)
+	}
+
+	var formats []Format
+	mpdBase := resolveRef(base, strings.TrimSpace(mpd.BaseURL))
+	for _, period := range mpd.Periods {
+		periodBase := resolveRef(mpdBase, strings.TrimSpace(period.BaseURL))
+
+		for _, set := range period.AdaptationSets {
+			setBase := resolveRef(periodBase, strings.TrimSpace(set.BaseURL))
+
+			for _, rep := range set.Representations {
+				formats = append(formats, dashFormat(base, setBase, set, rep))
+			}
+		}
+	}
+
+	return formats, nil
+}
+
+func dashFormat(manifestURL string, setBase string, set dashAdaptationSet, rep dashRepresentation) Format {
+	format := Format{
+		URL:      manifestURL,
+		MimeType: rep.MimeType,
+		Codec:    rep.Codecs,
+		Bitrate:  rep.Bandwidth,
+		Width:    rep.Width,
+		Height:   rep.Height,
+		Protocol: ProtocolDASH,
+	}
+
+	// a single file per representation can be fetched directly
+	if baseURL := strings.TrimSpace(rep.BaseURL); baseURL != "" {
+		format.URL = resolveRef(setBase, baseURL)
+	}
+
+	// representations inherit from their adaptation set
+	if format.MimeType == "" {
+		format.MimeType = set.MimeType
+	}
+	if format.Codec == "" {
+		format.Codec = set.Codecs
+	}
+	sampleRate := rep.AudioSamplingRate
+	if sampleRate == "" {
+		sampleRate = set.AudioSamplingRate
+	}
+	format.SampleRate, _ = strconv.Atoi(sampleRate)
+
+	format.Container, _ = parseMimeType(format.MimeType)
+
+	kind := set.ContentType
+	if kind == "" {
+		kind = strings.SplitN(format.MimeType, "/", 2)[0]
+	}
+	format.HasAudio = kind == "audio"
+	format.HasVideo = kind == "video"
+
+	// muxed representations declare both codecs
+	if audio, video := codecKinds(format.Codec); audio && video {
+		format.HasAudio, format.HasVideo = true, true
+	}
+
+	return format
+}
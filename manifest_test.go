@@ -0,0 +1,176 @@
+package StreamTool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testHLSMaster = `#EXTM3U
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",DEFAULT=YES,URI="audio/en.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="Muxed",DEFAULT=NO
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English",URI="subs/en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,AVERAGE-BANDWIDTH=1000000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=1280x720,AUDIO="aac"
+720p/index.m3u8
+
+#EXT-X-STREAM-INF:BANDWIDTH=64000,CODECS="mp4a.40.5"
+https://cdn.example.com/audio-only.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=400000,RESOLUTION=640x360
+/abs/360p.m3u8
+`
+
+const testHLSMedia = `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXTINF:6.0,
+seg0.ts
+#EXTINF:6.0,
+seg1.ts
+`
+
+func TestParseHLSMaster(t *testing.T) {
+	formats, err := ParseHLS(strings.NewReader(testHLSMaster), "https://example.com/live/master.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Format{
+		{URL: "https://example.com/live/audio/en.m3u8", HasAudio: true},
+		{URL: "https://example.com/live/720p/index.m3u8", Codec: "avc1.4d401f,mp4a.40.2", Bitrate: 1000000, Width: 1280, Height: 720, HasAudio: true, HasVideo: true},
+		{URL: "https://cdn.example.com/audio-only.m3u8", Codec: "mp4a.40.5", Bitrate: 64000, HasAudio: true},
+		{URL: "https://example.com/abs/360p.m3u8", Bitrate: 400000, Width: 640, Height: 360, HasAudio: true, HasVideo: true},
+	}
+	if len(formats) != len(want) {
+		t.Fatalf("got %d formats, want %d: %+v", len(formats), len(want), formats)
+	}
+	for i, format := range formats {
+		w := want[i]
+		w.MimeType, w.Container, w.Protocol = "application/vnd.apple.mpegurl", "m3u8", ProtocolHLS
+		if format != w {
+			t.Errorf("format %d = %+v, want %+v", i, format, w)
+		}
+	}
+}
+
+func TestParseHLSMedia(t *testing.T) {
+	formats, err := ParseHLS(strings.NewReader(testHLSMedia), "https://example.com/a.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(formats) != 1 || formats[0].URL != "https://example.com/a.m3u8" || formats[0].Protocol != ProtocolHLS {
+		t.Errorf("formats = %+v, want the playlist itself", formats)
+	}
+}
+
+func TestParseHLSInvalid(t *testing.T) {
+	for _, manifest := range []string{"", "not a playlist\n#EXTM3U"} {
+		if _, err := ParseHLS(strings.NewReader(manifest), "https://example.com/"); !errors.Is(err, ErrManifest) {
+			t.Errorf("ParseHLS(%q) error = %v, want ErrManifest", manifest, err)
+		}
+	}
+}
+
+const testDASH = `<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011">
+  <BaseURL>https://cdn.example.com/media/</BaseURL>
+  <Period>
+    <BaseURL>period1/</BaseURL>
+    <AdaptationSet mimeType="audio/mp4" codecs="mp4a.40.2" audioSamplingRate="44100">
+      <BaseURL>audio/</BaseURL>
+      <Representation id="a1" bandwidth="128000"><BaseURL>128k.m4a</BaseURL></Representation>
+      <Representation id="a2" bandwidth="48000" codecs="mp4a.40.5" audioSamplingRate="22050"><BaseURL>/root/48k.m4a</BaseURL></Representation>
+    </AdaptationSet>
+    <AdaptationSet contentType="video">
+      <Representation id="v1" mimeType="video/webm" codecs="vp9" bandwidth="2500000" width="1920" height="1080"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="m1" codecs="avc1.42001e, mp4a.40.2" bandwidth="800000" width="640" height="360"><BaseURL>muxed.mp4</BaseURL></Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func TestParseDASH(t *testing.T) {
+	formats, err := ParseDASH(strings.NewReader(testDASH), "https://example.com/manifest.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Format{
+		// MPD, Period and AdaptationSet BaseURLs stack up
+		{URL: "https://cdn.example.com/media/period1/audio/128k.m4a", MimeType: "audio/mp4", Codec: "mp4a.40.2", Container: "m4a", Bitrate: 128000, SampleRate: 44100, HasAudio: true},
+		{URL: "https://cdn.example.com/root/48k.m4a", MimeType: "audio/mp4", Codec: "mp4a.40.5", Container: "m4a", Bitrate: 48000, SampleRate: 22050, HasAudio: true},
+		// segmented, so it points at the manifest
+		{URL: "https://example.com/manifest.mpd", MimeType: "video/webm", Codec: "vp9", Container: "webm", Bitrate: 2500000, Width: 1920, Height: 1080, HasVideo: true},
+		{URL: "https://cdn.example.com/media/period1/muxed.mp4", MimeType: "video/mp4", Codec: "avc1.42001e, mp4a.40.2", Container: "mp4", Bitrate: 800000, Width: 640, Height: 360, HasAudio: true, HasVideo: true},
+	}
+	if len(formats) != len(want) {
+		t.Fatalf("got %d formats, want %d: %+v", len(formats), len(want), formats)
+	}
+	for i, format := range formats {
+		w := want[i]
+		w.Protocol = ProtocolDASH
+		if format != w {
+			t.Errorf("format %d = %+v, want %+v", i, format, w)
+		}
+	}
+
+	if _, err := ParseDASH(strings.NewReader("<MPD><Period>"), "https://example.com/"); !errors.Is(err, ErrManifest) {
+		t.Errorf("truncated mpd error = %v, want ErrManifest", err)
+	}
+}
+
+func TestCodecKinds(t *testing.T) {
+	tests := []struct {
+		codecs       string
+		audio, video bool
+	}{
+		{"mp4a.40.2", true, false},
+		{"avc1.4d401f,mp4a.40.2", true, true},
+		{" AVC1.4D401F , Opus ", true, true},
+		{"vp09.00.10.08", false, true},
+		{"ec-3", true, false},
+		{"stpp.ttml.im1t", false, false},
+		{"", false, false},
+	}
+
+	for _, test := range tests {
+		audio, video := codecKinds(test.codecs)
+		if audio != test.audio || video != test.video {
+			t.Errorf("codecKinds(%q) = %v, %v, want %v, %v", test.codecs, audio, video, test.audio, test.video)
+		}
+	}
+}
+
+func TestManifestFormatsErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			fmt.Fprint(w, testHLSMaster)
+		case "/page.html":
+			fmt.Fprint(w, "<html></html>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{}
+	if formats, err := c.ManifestFormats(context.Background(), srv.URL+"/master.m3u8"); err != nil || len(formats) != 4 {
+		t.Errorf("ManifestFormats = %d formats, %v, want 4", len(formats), err)
+	}
+
+	var extractErr *ExtractError
+	_, err := c.ManifestFormats(context.Background(), srv.URL+"/missing.m3u8")
+	if !errors.Is(err, ErrUnavailable) || !errors.As(err, &extractErr) || extractErr.StatusCode != http.StatusNotFound {
+		t.Errorf("missing manifest error = %v, want ErrUnavailable with its status", err)
+	}
+
+	_, err = c.ManifestFormats(context.Background(), srv.URL+"/page.html")
+	if !errors.Is(err, ErrManifest) || !errors.As(err, &extractErr) || extractErr.Stage != StageDecode {
+		t.Errorf("unknown manifest error = %v, want a decode ExtractError matching ErrManifest", err)
+	}
+}
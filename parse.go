@@ -1,8 +1,11 @@
 package StreamTool
 
 import (
-	"errors"
+	"context"
 	"regexp"
+	"sort"
+	"sync"
+	"time"
 )
 
 type StreamData struct {
@@ -11,24 +14,284 @@ type StreamData struct {
 	StreamURL string
 	Duration  int
 	ImageURL  string
+
+	// how StreamURL is served, one of the Protocol constants
+	Protocol string
+
+	// the stream is being broadcast, so it has no duration and StreamURL is
+	// usually a manifest
+	IsLive bool
+
+	// only a preview of PreviewDuration seconds can be played, such as
+	// soundcloud go+ tracks without a subscription. Duration is still the
+	// length of the whole track
+	Preview         bool
+	PreviewDuration int
+
+	// seconds into the stream playback should start, from links such as
+	// youtube's t= parameter
+	StartOffset int
+
+	// seconds into the stream playback should stop, 0 to play to the end
+	EndOffset int
+
+	// playlist the link was shared from, the index counts from 1
+	PlaylistID    string
+	PlaylistIndex int
+
+	// sections of the stream, such as the songs of a mix, see SplitChapters
+	Chapters []Chapter
+
+	// subtitle tracks, see FetchCaptions
+	Captions []Caption
+
+	// every stream available, StreamURL is the best audio among them
+	Formats []Format
+
+	// metadata, filled in when the site provides it
+	Artist      string
+	Album       string
+	TrackNumber int
+	Uploader    string
+	UploaderID  string
+	UploadDate  time.Time
+	Genre       string
+	Description string
+	Tags        []string
+	ViewCount   int64
+
+	// problems that didn't stop extraction, e.g. throttled formats
+	Warnings []string
 }
 
-var linkParsers = map[*regexp.Regexp](func(string, *regexp.Regexp) ([]StreamData, error)){
-	regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube\.com\/watch\?v=(.+)`):              parseYoutube,
-	regexp.MustCompile(`https:\/\/youtu\.be\/(.+)`):                                        parseYoutube,
-	regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube\.com\/playlist\?list=(.+)`):        parseYoutubePlaylist,
-	regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube\.com\/results\?search_query=(.+)`): parseYoutubePlaylist,
-	regexp.MustCompile(`https:\/\/(?:www\.)?soundcloud\.com\/.+\/.+`):                      parseSoundcloud,
-	regexp.MustCompile(`(https:\/\/.+\.bandcamp\.com)\/(track|album)\/.+`):                 parseBandcamp,
+// Kind is what a url points to.
+type Kind string
+
+const (
+	KindTrack    Kind = "track"
+	KindPlaylist Kind = "playlist"
+	KindAlbum    Kind = "album"
+	KindSearch   Kind = "search"
+)
+
+// PlaylistInfo describes a playlist, album or set as a whole.
+type PlaylistInfo struct {
+	ID          string
+	URL         string
+	Title       string
+	Owner       string
+	OwnerID     string
+	Description string
+	ImageURL    string
+
+	// total number of entries, which can exceed len(Entries) when the
+	// playlist was cut short or has unavailable entries
+	Count int
 }
 
-func ParseURL(url string) ([]StreamData, error) {
-	for rx, parser := range linkParsers {
+// Result is everything a url resolves to. Playlist is nil for single tracks.
+type Result struct {
+	Kind     Kind
+	Playlist *PlaylistInfo
+	Entries  []StreamData
+}
+
+// Extractor resolves urls belonging to a single site.
+type Extractor interface {
+	// short identifier of the site, e.g. "youtube"
+	Name() string
+
+	// reports whether the extractor handles url
+	Match(url string) bool
+
+	// the Result should be non nil even on error, holding whatever entries
+	// were resolved
+	Extract(ctx context.Context, c *Client, url string) (*Result, error)
+}
+
+type registeredExtractor struct {
+	extractor Extractor
+	priority  int
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   []registeredExtractor
+)
+
+// Register adds e to the extractors tried by ParseURL. Extractors are matched
+// from the highest priority down, ties going to whichever was registered
+// first. The built in extractors use priority 0.
+func Register(e Extractor, priority int) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+
+	extractors = append(extractors, registeredExtractor{e, priority})
+	sort.SliceStable(extractors, func(i int, j int) bool {
+		return extractors[i].priority > extractors[j].priority
+	})
+}
+
+// Extractors returns the registered extractors in the order they are matched.
+func Extractors() []Extractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+
+	list := make([]Extractor, len(extractors))
+	for i, reg := range extractors {
+		list[i] = reg.extractor
+	}
+	return list
+}
+
+// first extractor accepting url, nil if there are none
+func findExtractor(url string) Extractor {
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+
+	for _, reg := range extractors {
+		if reg.extractor.Match(url) {
+			return reg.extractor
+		}
+	}
+	return nil
+}
+
+// extractor built from url patterns, the matching pattern is handed to parse
+type regexExtractor struct {
+	name     string
+	patterns []*regexp.Regexp
+	parse    func(*Client, context.Context, string, *regexp.Regexp) (*Result, error)
+
+	// urls the patterns would accept but the extractor can't handle
+	exclude *regexp.Regexp
+}
+
+type trackParser func(*Client, context.Context, string, *regexp.Regexp) ([]StreamData, error)
+
+// adapt a parser that only ever returns a single track
+func track(parse trackParser) func(*Client, context.Context, string, *regexp.Regexp) (*Result, error) {
+	return func(c *Client, ctx context.Context, url string, urlRx *regexp.Regexp) (*Result, error) {
+		entries, err := parse(c, ctx, url, urlRx)
+		return &Result{Kind: KindTrack, Entries: entries}, err
+	}
+}
+
+func (e *regexExtractor) Name() string {
+	return e.name
+}
+
+func (e *regexExtractor) Match(url string) bool {
+	return e.pattern(url) != nil
+}
+
+func (e *regexExtractor) Extract(ctx context.Context, c *Client, url string) (*Result, error) {
+	rx := e.pattern(url)
+	if rx == nil {
+		return &Result{}, ErrUnsupportedURL
+	}
+	return e.parse(c, ctx, url, rx)
+}
+
+func (e *regexExtractor) pattern(url string) *regexp.Regexp {
+	if e.exclude != nil && e.exclude.MatchString(url) {
+		return nil
+	}
+	for _, rx := range e.patterns {
 		if rx.MatchString(url) {
-			data, err := parser(url, rx)
-			return data, err
+			return rx
 		}
 	}
+	return nil
+}
+
+func init() {
+	// playlist embeds also match the video embed pattern, so they go first
+	Register(&regexExtractor{
+		name: "youtube:playlist",
+		patterns: []*regexp.Regexp{
+			rxYoutubePlaylist,
+			regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube(?:-nocookie)?\.com\/embed\/videoseries\?(?:.*&)?list=([^&#]+)`),
+			regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube(?:-nocookie)?\.com\/embed\/?\?(?:.*&)?listType=playlist&(?:.*&)?list=([^&#]+)`),
+			regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube(?:-nocookie)?\.com\/embed\/?\?(?:.*&)?list=([^&#]+)&(?:.*&)?listType=playlist`),
+			regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube\.com\/results\?search_query=(.+)`),
+		},
+		parse: (*Client).parseYoutubePlaylist,
+	}, 0)
+
+	Register(&regexExtractor{
+		name: "youtube",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`https:\/\/(?:www\.|m\.|music\.)?youtube\.com\/watch\?(?:.*&)?v=([^&#]+)`),
+			regexp.MustCompile(`https:\/\/youtu\.be\/([^\/?&#]+)`),
+			regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube\.com\/(?:shorts|embed|live)\/([^\/?#&]+)`),
+			regexp.MustCompile(`https:\/\/(?:www\.)?youtube-nocookie\.com\/embed\/([^\/?#&]+)`),
+		},
+		parse: track((*Client).parseYoutube),
+	}, 0)
+
+	Register(&regexExtractor{
+		name: "youtube:channel",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`https:\/\/(?:www\.|m\.)?youtube\.com\/((?:@|channel\/|c\/|user\/)[^\/?#&]+)`),
+		},
+		parse: (*Client).parseYoutubeChannel,
+	}, 0)
+
+	Register(&regexExtractor{
+		name:     "soundcloud:short",
+		patterns: []*regexp.Regexp{rxSoundcloudShort},
+		parse:    (*Client).parseSoundcloudShort,
+	}, 0)
+
+	// sets and profiles also match the track pattern, so they go first
+	Register(&regexExtractor{
+		name:     "soundcloud:set",
+		patterns: []*regexp.Regexp{rxSoundcloudSet},
+		parse:    (*Client).parseSoundcloudSet,
+	}, 0)
+
+	Register(&regexExtractor{
+		name:     "soundcloud:user",
+		patterns: []*regexp.Regexp{rxSoundcloudUser},
+		parse:    (*Client).parseSoundcloudUser,
+		exclude:  rxSoundcloudReserved,
+	}, 0)
+
+	Register(&regexExtractor{
+		name: "soundcloud",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`https:\/\/(?:www\.|m\.)?soundcloud\.com\/.+\/.+`),
+		},
+		parse:   track((*Client).parseSoundcloud),
+		exclude: rxSoundcloudReserved,
+	}, 0)
+
+	Register(&regexExtractor{
+		name: "bandcamp",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(https:\/\/.+\.bandcamp\.com)\/(track|album)\/.+`),
+		},
+		parse: (*Client).parseBandcamp,
+	}, 0)
+}
+
+// ParseURL resolves url using DefaultClient.
+func ParseURL(url string) ([]StreamData, error) {
+	return DefaultClient.ParseURL(url)
+}
+
+// ParseURLContext resolves url using DefaultClient.
+func ParseURLContext(ctx context.Context, url string) ([]StreamData, error) {
+	return DefaultClient.ParseURLContext(ctx, url)
+}
+
+// Resolve resolves url using DefaultClient.
+func Resolve(url string) (*Result, error) {
+	return DefaultClient.Resolve(url)
+}
 
-	return []StreamData{}, errors.New("not an accepted url")
+// ResolveContext resolves url using DefaultClient.
+func ResolveContext(ctx context.Context, url string) (*Result, error) {
+	return DefaultClient.ResolveContext(ctx, url)
 }
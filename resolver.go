@@ -0,0 +1,234 @@
+package StreamTool
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultWorkers is how many entries a Resolver works on at once by default.
+const DefaultWorkers = 4
+
+// Resolver fills in partial entries, such as those of a playlist, by
+// resolving each one's url. The zero value is ready to use.
+type Resolver struct {
+	// used to resolve each entry, defaults to DefaultClient
+	Client *Client
+
+	// entries resolved at once, defaults to DefaultWorkers
+	Workers int
+
+	// minimum time between starting entries on the same host, 0 for no limit
+	HostInterval time.Duration
+}
+
+// Resolved is the outcome of resolving a single entry.
+type Resolved struct {
+	// position of the entry in the input
+	Index int
+
+	// the resolved entry, or the partial one it started from on error. Previews
+	// are resolved but also fail with ErrPreviewOnly
+	Entry StreamData
+	Err   error
+}
+
+// Resolve resolves every entry and returns them in their original order.
+// Entries left over when ctx is cancelled fail with its error.
+func (r *Resolver) Resolve(ctx context.Context, entries []StreamData) []Resolved {
+	results := make([]Resolved, len(entries))
+	sent := 0
+	for res := range r.Stream(ctx, entries) {
+		results[res.Index] = res
+		sent++
+	}
+
+	for i := sent; i < len(entries); i++ {
+		results[i] = Resolved{Index: i, Entry: entries[i], Err: ctx.Err()}
+	}
+	return results
+}
+
+// Stream resolves entries in the background and sends each result in order,
+// as soon as it and those before it are done. The channel is closed after the
+// last entry, or once ctx is cancelled. Callers must either read it until it
+// is closed or cancel ctx, otherwise the workers are left blocked forever.
+func (r *Resolver) Stream(ctx context.Context, entries []StreamData) <-chan Resolved {
+	out := make(chan Resolved)
+	jobs := make(chan int)
+	done := make(chan Resolved)
+
+	workers := r.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+
+	limiter := &hostLimiter{interval: r.HostInterval, next: map[string]time.Time{}}
+
+	// every entry is handed out, once ctx is cancelled they fail straight away
+	go func() {
+		for i := range entries {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				done <- r.resolve(ctx, limiter, i, entries[i])
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// hold back results that finish early until those before them are sent
+	go func() {
+		defer close(out)
+
+		pending := map[int]Resolved{}
+		next := 0
+		cancelled := false
+
+		for res := range done {
+			pending[res.Index] = res
+
+			for !cancelled {
+				res, ok := pending[next]
+				if !ok {
+					break
+				}
+
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					// keep draining so the workers can finish
+					cancelled = true
+				}
+
+				delete(pending, next)
+				next++
+			}
+		}
+	}()
+
+	return out
+}
+
+func (r *Resolver) resolve(ctx context.Context, limiter *hostLimiter, index int, entry StreamData) Resolved {
+	res := Resolved{Index: index, Entry: entry}
+
+	// already complete, e.g. bandcamp album tracks
+	if entry.StreamURL != "" {
+		return res
+	}
+
+	if err := ctx.Err(); err != nil {
+		res.Err = err
+		return res
+	}
+
+	if u, err := url.Parse(entry.URL); err == nil {
+		if err := limiter.wait(ctx, u.Host); err != nil {
+			res.Err = err
+			return res
+		}
+	}
+
+	c := r.Client
+	if c == nil {
+		c = DefaultClient
+	}
+
+	// previews still come back with a playable entry
+	streamData, err := c.ParseURLContext(ctx, entry.URL)
+	if err != nil && !(errors.Is(err, ErrPreviewOnly) && len(streamData) > 0) {
+		res.Err = err
+		return res
+	}
+	if len(streamData) == 0 {
+		res.Err = ErrUnavailable
+		return res
+	}
+
+	res.Entry = mergeEntry(entry, streamData[0])
+	res.Err = err
+	return res
+}
+
+// resolved entry, keeping what the partial one knew that the resolved one doesn't
+func mergeEntry(partial StreamData, resolved StreamData) StreamData {
+	if resolved.Title == "" {
+		resolved.Title = partial.Title
+	}
+	if resolved.Duration == 0 {
+		resolved.Duration = partial.Duration
+	}
+	if resolved.ImageURL == "" {
+		resolved.ImageURL = partial.ImageURL
+	}
+	if resolved.Uploader == "" {
+		resolved.Uploader = partial.Uploader
+	}
+	if resolved.UploaderID == "" {
+		resolved.UploaderID = partial.UploaderID
+	}
+
+	// only the playlist knows where the entry sits in it
+	if resolved.PlaylistID == "" {
+		resolved.PlaylistID = partial.PlaylistID
+	}
+	if resolved.PlaylistIndex == 0 {
+		resolved.PlaylistIndex = partial.PlaylistIndex
+	}
+	return resolved
+}
+
+// spaces out requests to each host
+type hostLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// block until a request to host may start
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l.interval <= 0 {
+		return nil
+	}
+
+	// reserve the next slot, so waiting callers queue up in turn
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,239 @@
+package StreamTool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResolverPreview(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.js":
+			fmt.Fprint(w, `x={client_id:"ID"}`)
+		case "/media/1/preview":
+			fmt.Fprint(w, `{"url":"https://cf-preview-media.sndcdn.com/preview"}`)
+		default:
+			fmt.Fprint(w, `<script crossorigin src="`+srv.URL+`/app.js"></script><script>window.__sc_hydration = [{"hydratable":"sound","data":{"id":1,"title":"t","duration":240000,"policy":"SNIP","media":{"transcodings":[
+				{"url":"https://api-v2.soundcloud.com/media/1/preview","preset":"mp3_0_0","snipped":true,"duration":30000,"format":{"protocol":"progressive","mime_type":"audio/mpeg"}}
+			]}}}];</script>`)
+		}
+	}))
+	defer srv.Close()
+
+	resolver := &Resolver{Client: &Client{
+		BaseURLs: map[string]string{"soundcloud.com": srv.URL, "api-v2.soundcloud.com": srv.URL},
+	}}
+
+	partial := StreamData{URL: "https://soundcloud.com/u/t", Uploader: "u"}
+	results := resolver.Resolve(context.Background(), []StreamData{partial})
+
+	res := results[0]
+	if !errors.Is(res.Err, ErrPreviewOnly) {
+		t.Errorf("Err = %v, want ErrPreviewOnly", res.Err)
+	}
+	if res.Entry.StreamURL != "https://cf-preview-media.sndcdn.com/preview" {
+		t.Errorf("StreamURL = %q, want the preview", res.Entry.StreamURL)
+	}
+	if !res.Entry.Preview || res.Entry.PreviewDuration != 30 {
+		t.Errorf("Preview = %v, PreviewDuration = %d, want true and 30", res.Entry.Preview, res.Entry.PreviewDuration)
+	}
+	if res.Entry.Uploader != "u" {
+		t.Errorf("Uploader = %q, want it kept from the partial entry", res.Entry.Uploader)
+	}
+}
+
+// resolves https://resolver.test/<path> through the url BaseURLs maps it to,
+// taking the response body as the title
+type resolverTestExtractor struct{}
+
+func (resolverTestExtractor) Name() string { return "resolver-test" }
+
+func (resolverTestExtractor) Match(url string) bool {
+	return strings.HasPrefix(url, "https://resolver.test/")
+}
+
+func (resolverTestExtractor) Extract(ctx context.Context, c *Client, url string) (*Result, error) {
+	resp, err := c.get(ctx, url)
+	if err != nil {
+		return &Result{}, fetchError("resolver-test", StageFetch, "couldn't fetch url", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &Result{}, fetchError("resolver-test", StageFetch, "couldn't read body", err)
+	}
+	return &Result{Kind: KindTrack, Entries: []StreamData{{URL: url, Title: string(body)}}}, nil
+}
+
+func init() {
+	Register(resolverTestExtractor{}, 0)
+}
+
+// a client resolving resolver.test urls against handler
+func resolverTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &Client{BaseURLs: map[string]string{"resolver.test": srv.URL}}
+}
+
+func resolverTestEntries(n int) []StreamData {
+	entries := make([]StreamData, n)
+	for i := range entries {
+		entries[i] = StreamData{URL: fmt.Sprintf("https://resolver.test/%d", i), PlaylistID: "PL", PlaylistIndex: i + 1}
+	}
+	return entries
+}
+
+func TestResolverStreamOrder(t *testing.T) {
+	// later entries finish first
+	const n = 6
+	c := resolverTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		i, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		time.Sleep(time.Duration(n-i) * 15 * time.Millisecond)
+		fmt.Fprintf(w, "title %d", i)
+	})
+
+	resolver := &Resolver{Client: c, Workers: n}
+	next := 0
+	for res := range resolver.Stream(context.Background(), resolverTestEntries(n)) {
+		if res.Index != next {
+			t.Fatalf("got entry %d, want %d", res.Index, next)
+		}
+		if res.Err != nil {
+			t.Errorf("entry %d: %v", res.Index, res.Err)
+		}
+		if want := fmt.Sprintf("title %d", next); res.Entry.Title != want {
+			t.Errorf("entry %d title = %q, want %q", next, res.Entry.Title, want)
+		}
+		if res.Entry.PlaylistID != "PL" || res.Entry.PlaylistIndex != next+1 {
+			t.Errorf("entry %d playlist = %s #%d, want PL #%d", next, res.Entry.PlaylistID, res.Entry.PlaylistIndex, next+1)
+		}
+		next++
+	}
+	if next != n {
+		t.Errorf("got %d entries, want %d", next, n)
+	}
+}
+
+func TestResolverWorkers(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	c := resolverTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	results := (&Resolver{Client: c, Workers: 2}).Resolve(context.Background(), resolverTestEntries(8))
+	for _, res := range results {
+		if res.Err != nil {
+			t.Errorf("entry %d: %v", res.Index, res.Err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if most != 2 {
+		t.Errorf("%d entries ran at once, want 2", most)
+	}
+}
+
+func TestResolverHostInterval(t *testing.T) {
+	const interval = 40 * time.Millisecond
+
+	var mu sync.Mutex
+	var starts []time.Time
+	c := resolverTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+	})
+
+	resolver := &Resolver{Client: c, Workers: 4, HostInterval: interval}
+	resolver.Resolve(context.Background(), resolverTestEntries(4))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(starts) != 4 {
+		t.Fatalf("%d requests, want 4", len(starts))
+	}
+	sort.Slice(starts, func(i int, j int) bool { return starts[i].Before(starts[j]) })
+	for i := 1; i < len(starts); i++ {
+		// a little slack for the timer firing before the request is sent
+		if gap := starts[i].Sub(starts[i-1]); gap < interval-5*time.Millisecond {
+			t.Errorf("requests %d and %d were %v apart, want at least %v", i-1, i, gap, interval)
+		}
+	}
+}
+
+func TestResolverCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the third entry hangs until it's aborted
+	c := resolverTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/2" {
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+
+	stream := (&Resolver{Client: c, Workers: 1}).Stream(ctx, resolverTestEntries(5))
+	for i := 0; i < 2; i++ {
+		res := <-stream
+		if res.Index != i || res.Err != nil || res.Entry.Title != "ok" {
+			t.Errorf("result %d = %+v, want entry %d resolved", i, res, i)
+		}
+	}
+
+	cancel()
+	for res := range stream {
+		if res.Index < 2 || !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("entry %d error = %v after cancelling, want context.Canceled", res.Index, res.Err)
+		}
+	}
+
+	// Resolve hands back every entry, failing those it didn't get to
+	results := (&Resolver{Client: c}).Resolve(ctx, resolverTestEntries(3))
+	for i, res := range results {
+		if res.Index != i || !errors.Is(res.Err, context.Canceled) {
+			t.Errorf("result %d = %d, %v, want context.Canceled", i, res.Index, res.Err)
+		}
+		if res.Entry.URL != fmt.Sprintf("https://resolver.test/%d", i) {
+			t.Errorf("entry %d = %s, want the partial entry", i, res.Entry.URL)
+		}
+	}
+}
+
+func TestMergeEntry(t *testing.T) {
+	partial := StreamData{Title: "partial", Uploader: "u", PlaylistID: "PL", PlaylistIndex: 3}
+	merged := mergeEntry(partial, StreamData{Title: "resolved", StreamURL: "s"})
+	if merged.Title != "resolved" || merged.StreamURL != "s" || merged.Uploader != "u" {
+		t.Errorf("merged = %+v, want the resolved entry filled in from the partial one", merged)
+	}
+	if merged.PlaylistID != "PL" || merged.PlaylistIndex != 3 {
+		t.Errorf("playlist = %s #%d, want PL #3", merged.PlaylistID, merged.PlaylistIndex)
+	}
+}
@@ -1,13 +1,16 @@
 package StreamTool
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/ioutil"
 	"math"
+	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"golang.org/x/net/html"
 )
@@ -26,19 +29,99 @@ func findSoundcloudJSON(node *html.Node) bool {
 	return false
 }
 
+type soundcloudFormat struct {
+	Protocol  string
+	Mime_Type string
+}
+
 type soundcloudTranscoding struct {
-	Url string
+	Url     string
+	Preset  string
+	Quality string
+	Snipped bool
+	Format  soundcloudFormat
+
+	// in milliseconds, only the snippet's length for snipped ones
+	Duration int
+}
+
+// DefaultSoundcloudFormat prefers progressive downloads, then HLS in AAC,
+// then any HLS stream.
+const DefaultSoundcloudFormat = "bestaudio[proto=https]/bestaudio[codec^=mp4a]/bestaudio"
+
+func (c *Client) soundcloudFormat() string {
+	if c.SoundcloudFormat != "" {
+		return c.SoundcloudFormat
+	}
+	return DefaultSoundcloudFormat
+}
+
+// convert to a Format with the resolved stream url
+func (t soundcloudTranscoding) toFormat(streamURL string) Format {
+	container, codec := parseMimeType(t.Format.Mime_Type)
+	if codec == "" {
+		codec = container
+	}
+
+	// soundcloud doesn't publish bitrates, these are the known encodings
+	bitrate := 0
+	switch {
+	case strings.HasPrefix(t.Preset, "mp3"):
+		bitrate = 128000
+	case strings.HasPrefix(t.Preset, "opus"):
+		bitrate = 64000
+	case strings.HasPrefix(t.Preset, "aac_160k"):
+		bitrate = 160000
+	case strings.HasPrefix(t.Preset, "aac") && t.Quality == "hq":
+		bitrate = 256000
+	}
+
+	// hls streams resolve to an m3u8 playlist of segments
+	protocol := ProtocolHTTPS
+	if t.Format.Protocol == "hls" {
+		protocol = ProtocolHLS
+	}
+
+	return Format{
+		URL:       streamURL,
+		MimeType:  t.Format.Mime_Type,
+		Codec:     codec,
+		Container: container,
+		Bitrate:   bitrate,
+		HasAudio:  true,
+		Protocol:  protocol,
+	}
 }
 
 type soundcloudMedia struct {
 	Transcodings []soundcloudTranscoding
 }
 
+type soundcloudUser struct {
+	Id       int64
+	Username string
+}
+
+type soundcloudPublisherMetadata struct {
+	Artist      string
+	Album_Title string
+}
+
 type soundcloudData struct {
-	Artwork_url string
-	Duration    int
-	Title       string
-	Media       soundcloudMedia
+	Id                 int64
+	Artwork_url        string
+	Duration           int
+	Title              string
+	Media              soundcloudMedia
+	Permalink_Url      string
+	Description        string
+	Genre              string
+	Tag_List           string
+	Created_At         string
+	Playback_Count     int64
+	Policy             string
+	User               soundcloudUser
+	Publisher_Metadata soundcloudPublisherMetadata
 }
 
 type soundcloudHydratable struct {
@@ -50,17 +133,30 @@ type soundcloudStream struct {
 	Url string
 }
 
-func getSoundcloudStream(url string) (string, error) {
+// split a tag list such as `rap "hip hop"` into its tags
+func splitSoundcloudTags(list string) []string {
+	var tags []string
+	for _, match := range regexp.MustCompile(`"([^"]+)"|(\S+)`).FindAllStringSubmatch(list, -1) {
+		if match[1] != "" {
+			tags = append(tags, match[1])
+		} else {
+			tags = append(tags, match[2])
+		}
+	}
+	return tags
+}
+
+func (c *Client) getSoundcloudStream(ctx context.Context, url string) (string, error) {
 	// fetch json
-	resp, err := netClient.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
-		return "", fmt.Errorf("couldn't fetch url: %w", err)
+		return "", fetchError("soundcloud", StageStreamLookup, "couldn't fetch stream json", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("couldn't read body: %w", err)
+		return "", fetchError("soundcloud", StageStreamLookup, "couldn't read stream json", err)
 	}
 	response := string(body)
 
@@ -68,82 +164,149 @@ func getSoundcloudStream(url string) (string, error) {
 	var jsonData soundcloudStream
 	err = json.Unmarshal([]byte(response), &jsonData)
 	if err != nil {
-		return "", fmt.Errorf("couldn't parse json: %w", err)
+		return "", newExtractError("soundcloud", StageStreamLookup, ErrLayoutChanged, "couldn't parse stream json: %w", err)
 	}
 
 	return jsonData.Url, nil
 }
 
-func getSoundcloudClientID(doc string) (string, error) {
-	rxScripts := regexp.MustCompile(`<script crossorigin src="(.+?)"></script>`)
-	scripts := rxScripts.FindAllStringSubmatch(doc, -1)
+var rxSoundcloudScripts = regexp.MustCompile(`<script crossorigin src="(.+?)"></script>`)
+var rxSoundcloudClientID = regexp.MustCompile(`client_id:"(.+?)"`)
+
+func (c *Client) getSoundcloudClientID(ctx context.Context, doc string) (string, error) {
+	scripts := rxSoundcloudScripts.FindAllStringSubmatch(doc, -1)
 
 	// loop in reverse as the desired script is usually last on the page
 	for i := len(scripts) - 1; i >= 0; i-- {
-		src := scripts[i][1]
-
-		// fetch script
-		resp, err := netClient.Get(src)
-		if err != nil {
-			return "", fmt.Errorf("couldn't fetch script: %w", err)
-		}
-		defer resp.Body.Close()
-
-		body, err := ioutil.ReadAll(resp.Body)
+		js, err := c.getSoundcloudScript(ctx, scripts[i][1])
 		if err != nil {
-			return "", fmt.Errorf("couldn't read script: %w", err)
+			return "", err
 		}
-		js := string(body)
 
 		// extract client id if found
 		if strings.Contains(js, `client_id:"`) {
-			rxClientID := regexp.MustCompile(`client_id:"(.+?)"`).FindAllStringSubmatch(js, 1)
-			if len(rxClientID) == 0 {
-				return "", errors.New("couldn't match client id")
+			match := rxSoundcloudClientID.FindStringSubmatch(js)
+			if match == nil {
+				return "", newExtractError("soundcloud", StageStreamLookup, ErrLayoutChanged, "couldn't match client id")
 			}
 
-			clientID := rxClientID[0][1]
-			return clientID, nil
+			return match[1], nil
 		}
 	}
 
-	return "", errors.New("couldn't find client id")
+	return "", newExtractError("soundcloud", StageStreamLookup, ErrLayoutChanged, "couldn't find client id")
 }
 
-func parseSoundcloud(url string, urlRx *regexp.Regexp) ([]StreamData, error) {
-	var streamData []StreamData
-	streamData = append(streamData, StreamData{})
-	streamData[0].URL = url
+func (c *Client) getSoundcloudScript(ctx context.Context, src string) (string, error) {
+	resp, err := c.get(ctx, src)
+	if err != nil {
+		return "", fetchError("soundcloud", StageStreamLookup, "couldn't fetch script", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fetchError("soundcloud", StageStreamLookup, "couldn't read script", err)
+	}
+
+	return string(body), nil
+}
+
+const soundcloudClientIDCacheKey = "soundcloud:client_id"
+
+// held while discovering a client id, so concurrent lookups share one
+var soundcloudClientIDMu sync.Mutex
+
+// the cached client id, discovered from the scripts of pageBody when there is
+// none or it equals stale
+func (c *Client) soundcloudClientID(ctx context.Context, pageBody string, stale string) (string, error) {
+	if cached, ok := c.cache().Get(soundcloudClientIDCacheKey); ok && len(cached) > 0 && string(cached) != stale {
+		return string(cached), nil
+	}
+
+	soundcloudClientIDMu.Lock()
+	defer soundcloudClientIDMu.Unlock()
+
+	// someone else may have found it while we waited
+	if cached, ok := c.cache().Get(soundcloudClientIDCacheKey); ok && len(cached) > 0 && string(cached) != stale {
+		return string(cached), nil
+	}
+
+	clientID, err := c.getSoundcloudClientID(ctx, pageBody)
+	if err != nil {
+		return "", err
+	}
+
+	c.cache().Set(soundcloudClientIDCacheKey, []byte(clientID))
+	return clientID, nil
+}
+
+// run call with the cached client id. Soundcloud rotates them, so when one is
+// rejected a new one is discovered and call is tried once more
+func (c *Client) withSoundcloudClientID(ctx context.Context, pageBody string, call func(clientID string) error) error {
+	clientID, err := c.soundcloudClientID(ctx, pageBody, "")
+	if err != nil {
+		return err
+	}
+
+	err = call(clientID)
+	if !soundcloudUnauthorized(err) {
+		return err
+	}
+
+	clientID, err = c.soundcloudClientID(ctx, pageBody, clientID)
+	if err != nil {
+		return err
+	}
 
+	return call(clientID)
+}
+
+// whether err is soundcloud refusing the client id
+func soundcloudUnauthorized(err error) bool {
+	var extractErr *ExtractError
+	if !errors.As(err, &extractErr) {
+		return false
+	}
+
+	return extractErr.StatusCode == http.StatusUnauthorized || extractErr.StatusCode == http.StatusForbidden
+}
+
+// fetch a page and decode its hydration tables, also returning the page for
+// the client id lookup
+func (c *Client) soundcloudHydration(ctx context.Context, url string) (string, []soundcloudHydratable, error) {
 	// fetch url
-	resp, err := netClient.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't fetch url: %w", err)
+		return "", nil, fetchError("soundcloud", StageFetch, "couldn't fetch url", err)
 	}
 	defer resp.Body.Close()
 
 	// read pageBody as string (needed later)
 	bodyReader, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't read body: %w", err)
+		return "", nil, fetchError("soundcloud", StageFetch, "couldn't read body", err)
 	}
 	pageBody := string(bodyReader)
 
 	// parse html
 	doc, err := html.Parse(strings.NewReader(pageBody))
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't parse html: %w", err)
+		return pageBody, nil, newExtractError("soundcloud", StageDecode, ErrLayoutChanged, "couldn't parse html: %w", err)
 	}
 
 	// find json script node
 	node := findNode(doc, findSoundcloudJSON)
 	if node == nil {
-		return streamData, errors.New("couldn't find json")
+		return pageBody, nil, newExtractError("soundcloud", StageLocateJSON, ErrLayoutChanged, "couldn't find json")
 	}
 	hydrationJSON := node.Data
 
 	// remove preceding "window.__sc_hydration = "
 	firstBracket := strings.Index(hydrationJSON, "[")
+	if firstBracket == -1 {
+		return pageBody, nil, newExtractError("soundcloud", StageLocateJSON, ErrLayoutChanged, "couldn't find hydration json")
+	}
 	hydrationJSON = hydrationJSON[firstBracket:]
 
 	// remove trailing ;
@@ -155,61 +318,172 @@ func parseSoundcloud(url string, urlRx *regexp.Regexp) ([]StreamData, error) {
 	var jsonData []soundcloudHydratable
 	err = json.Unmarshal([]byte(hydrationJSON), &jsonData)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't parse hydration json: %w", err)
+		return pageBody, nil, newExtractError("soundcloud", StageDecode, ErrLayoutChanged, "couldn't parse hydration json: %w", err)
 	}
 
-	// find and unmarshal sound data table
-	var soundData soundcloudData
-	found := false
-	for _, table := range jsonData {
-		if table.Hydratable == "sound" {
-			err = json.Unmarshal([]byte(table.Data), &soundData)
+	return pageBody, jsonData, nil
+}
+
+// find and unmarshal the named hydration table into v
+func findHydratable(tables []soundcloudHydratable, name string, v interface{}) error {
+	for _, table := range tables {
+		if table.Hydratable == name {
+			err := json.Unmarshal([]byte(table.Data), v)
 			if err != nil {
-				return streamData, fmt.Errorf("couldn't parse sound data json: %w", err)
+				return newExtractError("soundcloud", StageDecode, ErrLayoutChanged, "couldn't parse %s data json: %w", name, err)
 			}
-
-			found = true
-			break
+			return nil
 		}
 	}
 
-	if !found {
-		return streamData, errors.New("couldn't find sound hydration")
-	}
+	return newExtractError("soundcloud", StageLocateJSON, ErrLayoutChanged, "couldn't find %s hydration", name)
+}
 
-	// get first progressive transcoding
-	format_url := ""
-	for _, format := range soundData.Media.Transcodings {
-		if strings.Contains(format.Url, "/progressive") {
-			format_url = format.Url
-			break
+// seconds in the first snipped transcoding, 0 if there's none
+func snippetDuration(transcodings []soundcloudTranscoding) int {
+	for _, transcoding := range transcodings {
+		if transcoding.Snipped && transcoding.Duration > 0 {
+			return int(math.Ceil(float64(transcoding.Duration) / 1000.0))
 		}
 	}
+	return 0
+}
+
+// track info, without its streams
+func (d soundcloudData) toStreamData() StreamData {
+	var data StreamData
 
-	if format_url == "" {
-		return streamData, errors.New("couldn't find progressive stream")
+	// media info
+	data.Title = d.Title
+	data.Duration = int(math.Ceil(float64(d.Duration) / 1000.0))
+	data.ImageURL = d.Artwork_url
+
+	// metadata
+	data.URL = d.Permalink_Url
+
+	data.Artist = d.User.Username
+	if d.Publisher_Metadata.Artist != "" {
+		data.Artist = d.Publisher_Metadata.Artist
 	}
 
-	// get client id
-	clientID, err := getSoundcloudClientID(pageBody)
-	if err != nil {
-		return streamData, fmt.Errorf("couldn't get client id: %w", err)
+	data.Album = d.Publisher_Metadata.Album_Title
+	data.Uploader = d.User.Username
+	if d.User.Id != 0 {
+		data.UploaderID = strconv.FormatInt(d.User.Id, 10)
 	}
+	data.UploadDate = parseDate(d.Created_At)
+	data.Genre = d.Genre
+	data.Description = d.Description
+	data.Tags = splitSoundcloudTags(d.Tag_List)
+	data.ViewCount = d.Playback_Count
+
+	// go+ tracks are snipped for anyone without a subscription
+	if d.Policy == "SNIP" {
+		data.Preview = true
+		data.PreviewDuration = snippetDuration(d.Media.Transcodings)
+	}
+
+	return data
+}
 
-	// get stream url
-	fetch_url := format_url + "?client_id=" + clientID
+func (c *Client) parseSoundcloud(ctx context.Context, url string, urlRx *regexp.Regexp) ([]StreamData, error) {
+	url = canonicalSoundcloudURL(url)
 
-	stream_url, err := getSoundcloudStream(fetch_url)
+	var streamData []StreamData
+	streamData = append(streamData, StreamData{})
+	streamData[0].URL = url
+
+	var soundData soundcloudData
+	pageBody, err := c.soundcloudResource(ctx, url, "sound", &soundData)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't get stream url: %w", err)
+		return streamData, err
+	}
+
+	// progressive and hls transcodings of mp3, opus and aac, leaving out the
+	// encrypted hls ones. Snippets are only used when there's nothing else
+	var transcodings, snippets []soundcloudTranscoding
+	for _, transcoding := range soundData.Media.Transcodings {
+		if transcoding.Format.Protocol != "progressive" && transcoding.Format.Protocol != "hls" {
+			continue
+		}
+
+		if transcoding.Snipped {
+			snippets = append(snippets, transcoding)
+		} else {
+			transcodings = append(transcodings, transcoding)
+		}
+	}
+	preview := false
+	if len(transcodings) == 0 && len(snippets) > 0 {
+		transcodings = snippets
+		preview = true
+	}
+	if len(transcodings) == 0 {
+		return streamData, newExtractError("soundcloud", StageStreamLookup, ErrUnavailable, "couldn't find any transcodings")
+	}
+
+	// choose from what the transcodings describe and only resolve the chosen
+	// one, each lookup being a request. Candidates point at their transcoding
+	// until then
+	candidates := make([]Format, len(transcodings))
+	byURL := map[string]soundcloudTranscoding{}
+	for i, transcoding := range transcodings {
+		candidates[i] = transcoding.toFormat(transcoding.Url)
+		byURL[transcoding.Url] = transcoding
 	}
 
-	streamData[0].StreamURL = stream_url
+	// move on to the next choice when a lookup fails
+	var lookupErr error
+	for {
+		choice, err := SelectFormat(candidates, c.soundcloudFormat())
+		if errors.Is(err, ErrNoFormat) && lookupErr != nil {
+			return streamData, lookupErr
+		}
+		if err != nil {
+			return streamData, newExtractError("soundcloud", StageStreamLookup, ErrUnavailable, "couldn't find a stream: %w", err)
+		}
+
+		transcoding := byURL[choice.URL]
+		var stream_url string
+		lookupErr = c.withSoundcloudClientID(ctx, pageBody, func(clientID string) error {
+			var err error
+			stream_url, err = c.getSoundcloudStream(ctx, transcoding.Url+"?client_id="+clientID)
+			return err
+		})
+		if lookupErr == nil {
+			streamData[0].Formats = []Format{transcoding.toFormat(stream_url)}
+			break
+		}
+
+		var remaining []Format
+		for _, candidate := range candidates {
+			if candidate.URL != choice.URL {
+				remaining = append(remaining, candidate)
+			}
+		}
+		candidates = remaining
+	}
+
+	streamData[0].StreamURL = streamData[0].Formats[0].URL
+	streamData[0].Protocol = streamData[0].Formats[0].Protocol
 
 	// media info
-	streamData[0].Title = soundData.Title
-	streamData[0].Duration = int(math.Ceil(float64(soundData.Duration) / 1000.0))
-	streamData[0].ImageURL = soundData.Artwork_url
+	entry := soundData.toStreamData()
+	entry.Formats = streamData[0].Formats
+	entry.StreamURL = streamData[0].StreamURL
+	entry.Protocol = streamData[0].Protocol
+	if entry.URL == "" {
+		entry.URL = url
+	}
+	streamData[0] = entry
+
+	// the snippet is still returned for callers happy to play it
+	streamData[0].Preview = preview
+	if !preview {
+		streamData[0].PreviewDuration = 0
+		return streamData, nil
+	}
 
-	return streamData, nil
+	streamData[0].PreviewDuration = snippetDuration(snippets)
+	return streamData, newExtractError("soundcloud", StageStreamLookup, ErrPreviewOnly, "only a %d second preview of the %d second track is available", streamData[0].PreviewDuration, streamData[0].Duration)
 }
@@ -0,0 +1,243 @@
+package StreamTool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var rxSoundcloudSet = regexp.MustCompile(`https:\/\/(?:www\.|m\.)?soundcloud\.com\/[^\/?#]+\/sets\/[^\/?#]+`)
+var rxSoundcloudUser = regexp.MustCompile(`https:\/\/(?:www\.|m\.)?soundcloud\.com\/[^\/?#]+(?:\/(tracks|likes|reposts))?\/?(?:[?#].*)?$`)
+
+// site pages living where a profile would, which the user and track patterns
+// would otherwise take for one
+var rxSoundcloudReserved = regexp.MustCompile(`https:\/\/(?:www\.|m\.)?soundcloud\.com\/(?:search|discover|stream|upload|you|settings|messages|notifications|charts|pages|terms-of-use|mobile|people|tags|jobs|imprint|pro|signin|signup|logout|feed|popular|connect|premium)(?:[\/?#]|$)`)
+
+// how many tracks the api hands out per request
+const soundcloudPageSize = 50
+
+type soundcloudPlaylistData struct {
+	Id            int64
+	Title         string
+	Permalink_Url string
+	Artwork_url   string
+	Description   string
+	Set_Type      string
+	Is_Album      bool
+	Track_Count   int
+	User          soundcloudUser
+
+	// only the first few tracks are complete, the rest just have an id
+	Tracks []soundcloudData
+}
+
+type soundcloudUserData struct {
+	Id            int64
+	Username      string
+	Permalink_Url string
+	Avatar_Url    string
+	Description   string
+	Track_Count   int
+	Likes_Count   int
+}
+
+// an entry of a user's tracks, likes or reposts. Tracks are listed as
+// they are, likes and reposts wrap them
+type soundcloudCollectionItem struct {
+	soundcloudData
+	Track *soundcloudData
+}
+
+type soundcloudCollection struct {
+	Collection []soundcloudCollectionItem
+	Next_Href  string
+}
+
+// fetch an api-v2 url and decode its json into v
+func (c *Client) soundcloudAPI(ctx context.Context, apiURL string, v interface{}) error {
+	resp, err := c.get(ctx, apiURL)
+	if err != nil {
+		return fetchError("soundcloud", StageFetch, "couldn't fetch api", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fetchError("soundcloud", StageFetch, "couldn't read api response", err)
+	}
+
+	err = json.Unmarshal(body, v)
+	if err != nil {
+		return newExtractError("soundcloud", StageDecode, ErrLayoutChanged, "couldn't parse api json: %w", err)
+	}
+
+	return nil
+}
+
+// set the client id of an api url, which next_href links leave out
+func withClientID(apiURL string, clientID string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return apiURL
+	}
+
+	query := u.Query()
+	query.Set("client_id", clientID)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (c *Client) parseSoundcloudSet(ctx context.Context, url string, urlRx *regexp.Regexp) (*Result, error) {
+	url = canonicalSoundcloudURL(url)
+	result := &Result{Kind: KindPlaylist}
+
+	var set soundcloudPlaylistData
+	pageBody, err := c.soundcloudResource(ctx, url, "playlist", &set)
+	if err != nil {
+		return result, err
+	}
+
+	if set.Is_Album || set.Set_Type == "album" || set.Set_Type == "ep" {
+		result.Kind = KindAlbum
+	}
+
+	result.Playlist = &PlaylistInfo{
+		ID:          strconv.FormatInt(set.Id, 10),
+		URL:         set.Permalink_Url,
+		Title:       set.Title,
+		Owner:       set.User.Username,
+		OwnerID:     strconv.FormatInt(set.User.Id, 10),
+		Description: set.Description,
+		ImageURL:    set.Artwork_url,
+		Count:       set.Track_Count,
+	}
+	if result.Playlist.URL == "" {
+		result.Playlist.URL = url
+	}
+
+	tracks := set.Tracks
+	if c.MaxPlaylistItems > 0 && len(tracks) > c.MaxPlaylistItems {
+		tracks = tracks[:c.MaxPlaylistItems]
+	}
+
+	// fill in the tracks the page only gave ids for
+	var missing []string
+	for _, track := range tracks {
+		if track.Permalink_Url == "" && track.Id != 0 {
+			missing = append(missing, strconv.FormatInt(track.Id, 10))
+		}
+	}
+
+	found := map[int64]soundcloudData{}
+	for start := 0; start < len(missing); start += soundcloudPageSize {
+		end := start + soundcloudPageSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		var batch []soundcloudData
+		err := c.withSoundcloudClientID(ctx, pageBody, func(clientID string) error {
+			apiURL := "https://api-v2.soundcloud.com/tracks?ids=" + strings.Join(missing[start:end], ",") + "&client_id=" + clientID
+			return c.soundcloudAPI(ctx, apiURL, &batch)
+		})
+		if err != nil {
+			return result, err
+		}
+
+		for _, track := range batch {
+			found[track.Id] = track
+		}
+	}
+
+	// tracks the api didn't return have been removed or made private
+	for _, track := range tracks {
+		if track.Permalink_Url == "" {
+			var ok bool
+			if track, ok = found[track.Id]; !ok {
+				continue
+			}
+		}
+		result.Entries = append(result.Entries, track.toStreamData())
+	}
+
+	return result, nil
+}
+
+func (c *Client) parseSoundcloudUser(ctx context.Context, url string, urlRx *regexp.Regexp) (*Result, error) {
+	url = canonicalSoundcloudURL(url)
+	result := &Result{Kind: KindPlaylist}
+
+	var user soundcloudUserData
+	pageBody, err := c.soundcloudResource(ctx, url, "user", &user)
+	if err != nil {
+		return result, err
+	}
+
+	result.Playlist = &PlaylistInfo{
+		ID:          strconv.FormatInt(user.Id, 10),
+		URL:         url,
+		Title:       user.Username,
+		Owner:       user.Username,
+		OwnerID:     strconv.FormatInt(user.Id, 10),
+		Description: user.Description,
+		ImageURL:    user.Avatar_Url,
+		Count:       user.Track_Count,
+	}
+
+	// the profile itself lists the user's tracks
+	endpoint := fmt.Sprintf("https://api-v2.soundcloud.com/users/%d/tracks", user.Id)
+	switch urlRx.FindStringSubmatch(url)[1] {
+	case "likes":
+		endpoint = fmt.Sprintf("https://api-v2.soundcloud.com/users/%d/likes", user.Id)
+		result.Playlist.Title = user.Username + " - Likes"
+		result.Playlist.Count = user.Likes_Count
+	case "reposts":
+		endpoint = fmt.Sprintf("https://api-v2.soundcloud.com/stream/users/%d/reposts", user.Id)
+		result.Playlist.Title = user.Username + " - Reposts"
+		result.Playlist.Count = 0
+	}
+
+	// follow next_href until the collection runs out
+	next := fmt.Sprintf("%s?limit=%d&linked_partitioning=1", endpoint, soundcloudPageSize)
+	seen := map[string]bool{}
+	for next != "" && (c.MaxPlaylistItems <= 0 || len(result.Entries) < c.MaxPlaylistItems) {
+		if seen[next] {
+			break
+		}
+		seen[next] = true
+
+		var page soundcloudCollection
+		err := c.withSoundcloudClientID(ctx, pageBody, func(clientID string) error {
+			return c.soundcloudAPI(ctx, withClientID(next, clientID), &page)
+		})
+		if err != nil {
+			return result, err
+		}
+
+		// liked and reposted playlists aren't tracks, so they're left out
+		for _, item := range page.Collection {
+			track := item.soundcloudData
+			if item.Track != nil {
+				track = *item.Track
+			}
+			if track.Permalink_Url == "" {
+				continue
+			}
+			result.Entries = append(result.Entries, track.toStreamData())
+		}
+
+		next = page.Next_Href
+	}
+
+	result.Entries = limitPlaylist(result.Entries, c.MaxPlaylistItems)
+	if result.Playlist.Count == 0 {
+		result.Playlist.Count = len(result.Entries)
+	}
+
+	return result, nil
+}
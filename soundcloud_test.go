@@ -0,0 +1,106 @@
+package StreamTool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// a track page offering mp3 progressive, aac hls and opus hls, where the aac
+// lookup fails
+func soundcloudTestServer(t *testing.T) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var lookups []string
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/app.js":
+			fmt.Fprint(w, `x={client_id:"ID"}`)
+		case strings.HasPrefix(r.URL.Path, "/media/"):
+			mu.Lock()
+			lookups = append(lookups, r.URL.Path)
+			mu.Unlock()
+
+			if r.URL.Path == "/media/1/hls-aac" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprintf(w, `{"url":"https://cf-media.sndcdn.com%s"}`, r.URL.Path)
+		default:
+			fmt.Fprint(w, `<script crossorigin src="`+srv.URL+`/app.js"></script><script>window.__sc_hydration = [{"hydratable":"sound","data":{"id":1,"title":"t","duration":1000,"media":{"transcodings":[
+				{"url":"https://api-v2.soundcloud.com/media/1/mp3","preset":"mp3_0_0","format":{"protocol":"progressive","mime_type":"audio/mpeg"}},
+				{"url":"https://api-v2.soundcloud.com/media/1/hls-aac","preset":"aac_160k","format":{"protocol":"hls","mime_type":"audio/mp4; codecs=\"mp4a.40.2\""}},
+				{"url":"https://api-v2.soundcloud.com/media/1/hls-opus","preset":"opus_0_0","format":{"protocol":"hls","mime_type":"audio/ogg; codecs=\"opus\""}}
+			]}}}];</script>`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &lookups
+}
+
+func TestSoundcloudFormatSelection(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     string
+		lookups  []string
+	}{
+		{"", "https://cf-media.sndcdn.com/media/1/mp3", []string{"/media/1/mp3"}},
+		{"bestaudio[ext=opus]", "https://cf-media.sndcdn.com/media/1/hls-opus", []string{"/media/1/hls-opus"}},
+		{"bestaudio[proto=hls]", "https://cf-media.sndcdn.com/media/1/hls-opus", []string{"/media/1/hls-aac", "/media/1/hls-opus"}},
+	}
+
+	for _, test := range tests {
+		srv, lookups := soundcloudTestServer(t)
+		c := &Client{
+			Cache:            NewMemoryCache(),
+			SoundcloudFormat: test.selector,
+			BaseURLs:         map[string]string{"soundcloud.com": srv.URL, "api-v2.soundcloud.com": srv.URL},
+		}
+
+		streamData, err := c.ParseURLContext(context.Background(), "https://soundcloud.com/u/t")
+		if err != nil {
+			t.Errorf("%q: %v", test.selector, err)
+			continue
+		}
+		if streamData[0].StreamURL != test.want {
+			t.Errorf("%q: StreamURL = %s, want %s", test.selector, streamData[0].StreamURL, test.want)
+		}
+		if strings.Join(*lookups, " ") != strings.Join(test.lookups, " ") {
+			t.Errorf("%q: looked up %q, want %q", test.selector, *lookups, test.lookups)
+		}
+	}
+}
+
+func TestSoundcloudReservedPaths(t *testing.T) {
+	for _, url := range []string{
+		"https://soundcloud.com/search?q=x",
+		"https://soundcloud.com/search/sounds?q=x",
+		"https://soundcloud.com/discover",
+		"https://soundcloud.com/stream",
+		"https://m.soundcloud.com/upload",
+		"https://soundcloud.com/you/likes",
+	} {
+		if _, err := (&Client{}).Resolve(url); !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("Resolve(%s) error = %v, want ErrUnsupportedURL", url, err)
+		}
+	}
+
+	tests := map[string]string{
+		"https://soundcloud.com/streamer":            "soundcloud:user",
+		"https://soundcloud.com/discovery/likes":     "soundcloud:user",
+		"https://soundcloud.com/pros/some-track":     "soundcloud",
+		"https://soundcloud.com/search-party/sets/x": "soundcloud:set",
+	}
+	for url, want := range tests {
+		if extractor := findExtractor(url); extractor == nil || extractor.Name() != want {
+			t.Errorf("extractor for %s = %v, want %s", url, extractor, want)
+		}
+	}
+}
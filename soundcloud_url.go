@@ -0,0 +1,98 @@
+package StreamTool
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// links shared from the app, which redirect to the permalink
+var rxSoundcloudShort = regexp.MustCompile(`https:\/\/on\.soundcloud\.com\/[^\/?#]+`)
+
+// short links are only ever expected to redirect once or twice
+const soundcloudMaxRedirects = 5
+
+// the desktop form of a mobile link, whose pages are built differently
+func canonicalSoundcloudURL(rawURL string) string {
+	return strings.Replace(rawURL, "://m.soundcloud.com/", "://soundcloud.com/", 1)
+}
+
+// follow a short link to its permalink and resolve that instead
+func (c *Client) parseSoundcloudShort(ctx context.Context, shortURL string, urlRx *regexp.Regexp) (*Result, error) {
+	target, err := c.soundcloudRedirect(ctx, shortURL)
+	if err != nil {
+		return &Result{Entries: []StreamData{{URL: shortURL}}}, err
+	}
+
+	if findExtractor(target) == nil {
+		return &Result{Entries: []StreamData{{URL: target}}}, newExtractError("soundcloud", StageFetch, ErrUnsupportedURL, "short link points to %s", target)
+	}
+	return c.ResolveContext(ctx, target)
+}
+
+// where a short link leads, without the tracking parameters added to it
+func (c *Client) soundcloudRedirect(ctx context.Context, shortURL string) (string, error) {
+	// redirects are followed by hand so BaseURLs applies to every hop
+	client := *c.httpClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	current := shortURL
+	for i := 0; i < soundcloudMaxRedirects; i++ {
+		req, err := c.newRequest(ctx, http.MethodGet, current, nil)
+		if err != nil {
+			return "", newExtractError("soundcloud", StageFetch, ErrNetwork, "couldn't create request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", fetchError("soundcloud", StageFetch, "couldn't follow short link", err)
+		}
+		resp.Body.Close()
+
+		location := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode > 399 || location == "" {
+			if resp.StatusCode > 299 {
+				return "", fetchError("soundcloud", StageFetch, "couldn't follow short link", &statusError{resp.StatusCode, resp.Status})
+			}
+			return "", newExtractError("soundcloud", StageFetch, ErrUnavailable, "short link didn't redirect")
+		}
+
+		current = resolveRef(current, location)
+		if u, err := url.Parse(current); err == nil && u.Host != "on.soundcloud.com" {
+			u.RawQuery = ""
+			u.Fragment = ""
+			return canonicalSoundcloudURL(u.String()), nil
+		}
+	}
+
+	return "", newExtractError("soundcloud", StageFetch, ErrUnavailable, "short link redirected too many times")
+}
+
+// decode the page's hydration table name into v, asking the api to resolve
+// pageURL instead when the page doesn't have one
+func (c *Client) soundcloudResource(ctx context.Context, pageURL string, name string, v interface{}) (string, error) {
+	pageBody, tables, err := c.soundcloudHydration(ctx, pageURL)
+	if err == nil {
+		err = findHydratable(tables, name, v)
+	}
+
+	var extractErr *ExtractError
+	if err == nil || !errors.As(err, &extractErr) || extractErr.Stage != StageLocateJSON {
+		return pageBody, err
+	}
+
+	resolveErr := c.withSoundcloudClientID(ctx, pageBody, func(clientID string) error {
+		apiURL := "https://api-v2.soundcloud.com/resolve?url=" + url.QueryEscape(pageURL) + "&client_id=" + clientID
+		return c.soundcloudAPI(ctx, apiURL, v)
+	})
+	if resolveErr != nil {
+		return pageBody, resolveErr
+	}
+
+	return pageBody, nil
+}
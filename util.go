@@ -28,13 +28,22 @@ func (w *stringWrapper) UnmarshalJSON(data []byte) (err error) {
 	return nil
 }
 
-// reverse string
-func reverseStr(str string) string {
-	var res string
-	for _, char := range str {
-		res = string(char) + res
+// date layouts used across the supported sites
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02",
+	"02 Jan 2006 15:04:05 MST",
+	"2006/01/02 15:04:05 -0700",
+}
+
+// parse a date in any of the known layouts, zero time if none match
+func parseDate(str string) time.Time {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, str); err == nil {
+			return t
+		}
 	}
-	return res
+	return time.Time{}
 }
 
 // custom http client with timeout
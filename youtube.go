@@ -1,14 +1,12 @@
 package StreamTool
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"net/url"
 	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 
@@ -43,151 +41,203 @@ func findManifestJSON(node *html.Node) bool {
 	return false
 }
 
-func youtubeSig(doc *html.Node, sig string) (string, error) {
-	// find and parse player config
-	node := findNode(doc, findPlayerJSON)
-	if node == nil {
-		return "", errors.New("couldn't find player json")
-	}
-
-	rxJsUrl := regexp.MustCompile(`"jsUrl":"(.+?)"`).FindAllStringSubmatch(node.Data, -1)
-	if len(rxJsUrl) == 0 {
-		return "", errors.New("couldn't match player js url")
-	}
-	jsUrl := rxJsUrl[0][1]
-
-	// fetch player js
-	resp, err := netClient.Get("https://www.youtube.com" + jsUrl)
-	if err != nil {
-		return "", fmt.Errorf("couldn't fetch player js: %w", err)
+// rebuild a playable url from a format's signature cipher
+func decipherFormatURL(ctx context.Context, signatureCipher string, sigFunc *youtubeJSFunc) (string, error) {
+	// stream url
+	rxUrl := regexp.MustCompile(`url=([^&]+)`).FindAllStringSubmatch(signatureCipher, 1)
+	if len(rxUrl) == 0 {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't match stream url")
 	}
-	defer resp.Body.Close()
+	raw_stream_url := rxUrl[0][1]
 
-	body, err := ioutil.ReadAll(resp.Body)
+	stream_url, err := url.QueryUnescape(raw_stream_url)
 	if err != nil {
-		return "", fmt.Errorf("couldn't read player js: %w", err)
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't unescape stream url: %w", err)
 	}
-	playerjs := string(body)
 
-	// linear instruction set
-	rxCipherInstructions := regexp.MustCompile(`\.split\(""\);(.*);return \w\.join\(""\)`).FindAllStringSubmatch(playerjs, 1)
-	if len(rxCipherInstructions) == 0 {
-		return "", errors.New("couldn't match cipher instructions")
+	// sig
+	rxSig := regexp.MustCompile(`(?:^|&)s=([^&]+)`).FindAllStringSubmatch(signatureCipher, 1)
+	if len(rxSig) == 0 {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't match signature string")
 	}
-	cipherInstructions := rxCipherInstructions[0][1]
+	raw_sig := rxSig[0][1]
 
-	// break down instructions into steps
-	cipherSteps := regexp.MustCompile(`(\w+).(\w+)\(\w+,(\d+)\);?`).FindAllStringSubmatch(cipherInstructions, -1)
-	if len(cipherSteps) == 0 {
-		return "", errors.New("couldn't match cipher steps")
-	}
-
-	// find function definitions
-	cipherFuncName := cipherSteps[0][1]
-	rxFunctions := `(\w+):function\([\w,]+\)\{.*\},?\n?`
-
-	rxCipherFuncBlock := regexp.MustCompile(fmt.Sprintf(`%s=\{(?:%s)+\};`, cipherFuncName, rxFunctions)).FindAllString(playerjs, 1)
-	if len(rxCipherFuncBlock) == 0 {
-		return "", errors.New("couldn't match cipher function block")
-	}
-
-	cipherFuncBlock := rxCipherFuncBlock[0]
-	cipherFuncDefs := regexp.MustCompile(rxFunctions).FindAllStringSubmatch(cipherFuncBlock, -1)
-
-	// determine what method each step uses
-	cipherFuncs := map[string]string{}
-	for _, match := range cipherFuncDefs {
-		str := match[0]
-		name := match[1]
-
-		if strings.Contains(str, "splice") {
-			cipherFuncs[name] = "splice"
-		} else if strings.Contains(str, "reverse") {
-			cipherFuncs[name] = "reverse"
-		} else if strings.Contains(str, "var ") {
-			cipherFuncs[name] = "swap"
-		} else {
-			return "", errors.New("unknown cipher transformation")
-		}
+	sig, err := url.QueryUnescape(raw_sig)
+	if err != nil {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't unescape sig: %w", err)
 	}
 
-	if len(cipherFuncs) == 0 {
-		return "", errors.New("empty cipher functions")
+	// sig policy
+	rxSp := regexp.MustCompile(`sp=([^&]+)`).FindAllStringSubmatch(signatureCipher, 1)
+	if len(rxSp) == 0 {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't match signature policy")
 	}
+	sp := rxSp[0][1]
 
-	// run the steps and decipher
-	for _, str := range cipherSteps {
-		fn := str[2]
-		arg := 0
-		if len(str) > 3 {
-			num, err := strconv.Atoi(str[3])
-			if err != nil {
-				return "", fmt.Errorf("couldn't convert arg number: %w", err)
-			}
-			arg = num
-		}
-
-		method := cipherFuncs[fn]
-		if method == "splice" {
-			sig = sig[arg:]
-		} else if method == "reverse" {
-			sig = reverseStr(sig)
-		} else if method == "swap" {
-			bytes := []byte(sig)
-			first := bytes[0]
-			bytes[0] = bytes[arg%len(bytes)]
-			bytes[arg%len(bytes)] = first
-			sig = string(bytes)
-		}
+	// reconstruct url
+	deciphered, err := sigFunc.call(ctx, sig)
+	if err != nil {
+		return "", err
 	}
 
-	return sig, nil
+	escSig := url.QueryEscape(deciphered)
+	return fmt.Sprintf("%s&%s=%s", stream_url, sp, escSig), nil
 }
 
 type youtubeFormat struct {
+	Itag            int
 	Url             string
 	Bitrate         int
 	MimeType        string
 	SignatureCipher string
+	Width           int
+	Height          int
+	ContentLength   string
+	AudioSampleRate string
+}
+
+// convert to a Format with the given (deciphered) url
+func (f youtubeFormat) toFormat(streamURL string) Format {
+	container, codec := parseMimeType(f.MimeType)
+	format := Format{
+		URL:       streamURL,
+		MimeType:  f.MimeType,
+		Codec:     codec,
+		Container: container,
+		Bitrate:   f.Bitrate,
+		HasAudio:  strings.HasPrefix(f.MimeType, "audio/") || f.AudioSampleRate != "",
+		HasVideo:  strings.HasPrefix(f.MimeType, "video/"),
+		Width:     f.Width,
+		Height:    f.Height,
+		Protocol:  ProtocolHTTPS,
+	}
+
+	format.SampleRate, _ = strconv.Atoi(f.AudioSampleRate)
+	format.Filesize, _ = strconv.ParseInt(f.ContentLength, 10, 64)
+
+	return format
 }
 
 type youtubeStreamingData struct {
 	Formats         []youtubeFormat
 	AdaptiveFormats []youtubeFormat
+	HlsManifestUrl  string
+	DashManifestUrl string
 }
 
 type youtubeVideoDetails struct {
-	Title         string
-	LengthSeconds string
+	Title            string
+	LengthSeconds    string
+	Author           string
+	ChannelId        string
+	ViewCount        string
+	Keywords         []string
+	ShortDescription string
+	IsLive           bool
+	IsLiveContent    bool
+}
+
+type youtubeMicroformatRenderer struct {
+	UploadDate  string
+	PublishDate string
+	Category    string
+}
+
+type youtubeMicroformat struct {
+	PlayerMicroformatRenderer youtubeMicroformatRenderer
+}
+
+type youtubeCaptions struct {
+	PlayerCaptionsTracklistRenderer struct {
+		CaptionTracks []struct {
+			BaseUrl      string
+			Name         youtubeText
+			LanguageCode string
+			Kind         string
+		}
+	}
 }
 
 type youtubeJSON struct {
-	StreamingData youtubeStreamingData
-	VideoDetails  youtubeVideoDetails
+	PlayabilityStatus youtubePlayabilityStatus
+	StreamingData     youtubeStreamingData
+	VideoDetails      youtubeVideoDetails
+	Microformat       youtubeMicroformat
+	Captions          youtubeCaptions
 }
 
-func parseYoutube(song_url string, urlRx *regexp.Regexp) ([]StreamData, error) {
+func (c *Client) parseYoutube(ctx context.Context, song_url string, urlRx *regexp.Regexp) ([]StreamData, error) {
+	link, err := parseYoutubeURL(song_url)
+	if err != nil {
+		return []StreamData{{URL: song_url}}, err
+	}
+
+	streamData, err := c.youtubeVideo(ctx, song_url, link.videoID)
+
+	// where in the video and playlist the link pointed
+	for i := range streamData {
+		streamData[i].StartOffset = link.start
+		streamData[i].PlaylistID = link.listID
+		streamData[i].PlaylistIndex = link.index
+	}
+
+	return streamData, err
+}
+
+// resolve a video from the watch page, falling back to each api client
+func (c *Client) youtubeVideo(ctx context.Context, song_url string, videoID string) ([]StreamData, error) {
+	var streamData []StreamData
+	var firstErr error
+	if !c.YoutubeSkipWebpage {
+		streamData, firstErr = c.parseYoutubeWebpage(ctx, song_url, videoID)
+		if firstErr == nil || ctx.Err() != nil || !youtubeRetryable(firstErr) {
+			return streamData, firstErr
+		}
+	}
+
+	// fall back to the api, which also serves videos the watch page withholds
+	for _, profile := range c.youtubeClients() {
+		data, err := c.parseYoutubeInnerTube(ctx, song_url, videoID, profile)
+		if err == nil {
+			return data, nil
+		}
+
+		if firstErr == nil {
+			streamData, firstErr = data, err
+		}
+		if ctx.Err() != nil || !youtubeRetryable(err) {
+			break
+		}
+	}
+
+	return streamData, firstErr
+}
+
+// scrape the player response out of the watch page
+func (c *Client) parseYoutubeWebpage(ctx context.Context, song_url string, videoID string) ([]StreamData, error) {
 	var streamData []StreamData
 	streamData = append(streamData, StreamData{})
 	streamData[0].URL = song_url
 
-	// fetch url
-	resp, err := netClient.Get(song_url)
+	// shorts, embeds and music links all share the watch page. It's asked
+	// for in english, like the innertube requests, as playability reasons
+	// are told apart by their wording
+	resp, err := c.get(ctx, "https://www.youtube.com/watch?v="+videoID+"&hl=en")
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't fetch url: %w", err)
+		return streamData, fetchError("youtube", StageFetch, "couldn't fetch url", err)
 	}
 	defer resp.Body.Close()
 
 	// parse html
 	doc, err := html.Parse(resp.Body)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't parse html: %w", err)
+		return streamData, newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't parse html: %w", err)
 	}
 
 	// find and parse manifest
 	node := findNode(doc, findManifestJSON)
 	if node == nil {
-		return streamData, errors.New("couldn't find manifest json")
+		return streamData, newExtractError("youtube", StageLocateJSON, ErrLayoutChanged, "couldn't find manifest json")
 	}
 
 	manifestJSON := node.Data
@@ -205,99 +255,198 @@ func parseYoutube(song_url string, urlRx *regexp.Regexp) ([]StreamData, error) {
 	var jsonData youtubeJSON
 	err = json.Unmarshal([]byte(manifestJSON), &jsonData)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't parse json: %w", err)
+		return streamData, newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't parse json: %w", err)
 	}
 
-	// find most appropriate format
-	formats := jsonData.StreamingData.Formats
-	formats = append(formats, jsonData.StreamingData.AdaptiveFormats...)
-
-	sort.Slice(formats, func(i int, j int) bool {
-		return formats[i].Bitrate < formats[j].Bitrate
-	})
-
-	var highestAudio string
-	var highestVideo string
-	ciphered := false
-
-	for _, format := range formats {
-		url := format.Url
-		if url == "" {
-			ciphered = true
-			url = format.SignatureCipher
+	// the page links the player, otherwise it's looked up when needed
+	jsUrl := ""
+	if node := findNode(doc, findPlayerJSON); node != nil {
+		if match := rxJsUrl.FindStringSubmatch(node.Data); match != nil {
+			jsUrl = match[1]
+			c.setYoutubePlayerURL(jsUrl)
 		}
+	}
+
+	streamData, err = c.youtubeStreamData(ctx, song_url, videoID, jsonData, jsUrl)
+	if err != nil {
+		return streamData, err
+	}
 
-		if highestAudio == "" && strings.HasPrefix(format.MimeType, "audio/") {
-			highestAudio = url
-		} else if highestVideo == "" && strings.HasPrefix(format.MimeType, "video/") {
-			highestVideo = url
+	// the progress bar's chapters beat those read from the description
+	if node := findNode(doc, findPlaylistJSON); node != nil && strings.Contains(node.Data, "{") {
+		page := node.Data[strings.Index(node.Data, "{"):]
+		if chapters := youtubeMarkerChapters(page, streamData[0].Duration); chapters != nil {
+			streamData[0].Chapters = chapters
 		}
 	}
 
-	var streamURL string
-	if highestAudio != "" {
-		streamURL = highestAudio
-	} else {
-		streamURL = highestVideo
+	return streamData, nil
+}
+
+// build the stream data from a player response, using the player at jsUrl to
+// unlock the formats
+func (c *Client) youtubeStreamData(ctx context.Context, song_url string, videoID string, jsonData youtubeJSON, jsUrl string) ([]StreamData, error) {
+	var streamData []StreamData
+	streamData = append(streamData, StreamData{})
+	streamData[0].URL = song_url
+
+	// there's nothing to unlock in a video youtube won't play
+	if err := jsonData.PlayabilityStatus.err(); err != nil {
+		return streamData, err
 	}
 
-	if ciphered {
-		// stream url
-		rxUrl := regexp.MustCompile(`url=([^&]+)`).FindAllStringSubmatch(streamURL, 1)
-		if len(rxUrl) == 0 {
-			return streamData, errors.New("couldn't match stream url")
+	streaming := jsonData.StreamingData
+	progressive := len(streaming.Formats) + len(streaming.AdaptiveFormats)
+
+	// live streams are only served through their manifests
+	if jsonData.VideoDetails.IsLive || (progressive == 0 && (streaming.HlsManifestUrl != "" || streaming.DashManifestUrl != "")) {
+		if err := c.youtubeLive(ctx, &streamData[0], streaming); err != nil {
+			return streamData, err
 		}
-		raw_stream_url := rxUrl[0][1]
+	} else if err := c.youtubeFormats(ctx, &streamData[0], streaming, jsUrl); err != nil {
+		return streamData, err
+	}
 
-		stream_url, err := url.QueryUnescape(raw_stream_url)
+	// video details
+	streamData[0].Title = jsonData.VideoDetails.Title
+
+	// live streams have no length yet
+	if !streamData[0].IsLive || jsonData.VideoDetails.LengthSeconds != "" && jsonData.VideoDetails.LengthSeconds != "0" {
+		num, err := strconv.ParseFloat(jsonData.VideoDetails.LengthSeconds, 64)
 		if err != nil {
-			return streamData, fmt.Errorf("couldn't unescape stream url: %w", err)
+			return streamData, newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't parse duration: %w", err)
 		}
+		streamData[0].Duration = int(math.Ceil(num))
+	}
 
-		// sig
-		rxSig := regexp.MustCompile(`s=([^&]+)`).FindAllStringSubmatch(streamURL, 1)
-		if len(rxSig) == 0 {
-			return streamData, errors.New("couldn't match signature string")
-		}
-		raw_sig := rxSig[0][1]
+	streamData[0].ImageURL = fmt.Sprintf("https://i.ytimg.com/vi/%s/maxresdefault.jpg", videoID)
 
-		sig, err := url.QueryUnescape(raw_sig)
-		if err != nil {
-			return streamData, fmt.Errorf("couldn't unescape sig: %w", err)
+	// metadata
+	details := jsonData.VideoDetails
+	microformat := jsonData.Microformat.PlayerMicroformatRenderer
+
+	streamData[0].Uploader = details.Author
+	streamData[0].UploaderID = details.ChannelId
+	streamData[0].Description = details.ShortDescription
+	streamData[0].Chapters = parseDescriptionChapters(details.ShortDescription, streamData[0].Duration)
+
+	// speech recognition tracks are of kind "asr"
+	for _, track := range jsonData.Captions.PlayerCaptionsTracklistRenderer.CaptionTracks {
+		streamData[0].Captions = append(streamData[0].Captions, Caption{
+			Language:      track.LanguageCode,
+			Name:          track.Name.String(),
+			AutoGenerated: track.Kind == "asr",
+			URL:           track.BaseUrl,
+		})
+	}
+	streamData[0].Tags = details.Keywords
+	streamData[0].Genre = microformat.Category
+
+	// auto generated music channels are named "Artist - Topic"
+	if strings.HasSuffix(details.Author, " - Topic") {
+		streamData[0].Artist = strings.TrimSuffix(details.Author, " - Topic")
+	}
+
+	if views, err := strconv.ParseInt(details.ViewCount, 10, 64); err == nil {
+		streamData[0].ViewCount = views
+	}
+
+	uploadDate := microformat.UploadDate
+	if uploadDate == "" {
+		uploadDate = microformat.PublishDate
+	}
+	streamData[0].UploadDate = parseDate(uploadDate)
+
+	return streamData, nil
+}
+
+// unlock the progressive and adaptive formats with the player at jsUrl
+func (c *Client) youtubeFormats(ctx context.Context, data *StreamData, streaming youtubeStreamingData, jsUrl string) error {
+	// collect every format
+	formats := streaming.Formats
+	formats = append(formats, streaming.AdaptiveFormats...)
+
+	// only fetch the player when a format is ciphered or scrambled
+	var player *youtubePlayer
+	var err error
+	for _, format := range formats {
+		if format.Url == "" || hasNParam(format.Url) {
+			player, err = c.youtubeSig(ctx, jsUrl)
+			if err != nil {
+				player = &youtubePlayer{sigErr: err, nErr: err}
+			}
+			break
 		}
+	}
 
-		// sig policy
-		rxSp := regexp.MustCompile(`sp=([^&]+)`).FindAllStringSubmatch(streamURL, 1)
-		if len(rxSp) == 0 {
-			return streamData, errors.New("couldn't match signature policy")
+	var throttleErr error
+	for _, format := range formats {
+		streamURL := format.Url
+		if streamURL == "" {
+			if player.sigErr != nil {
+				return player.sigErr
+			}
+
+			streamURL, err = decipherFormatURL(ctx, format.SignatureCipher, player.sigFunc)
+			if err != nil {
+				return err
+			}
 		}
-		sp := rxSp[0][1]
 
-		// do the deciphering
-		deciphered, err := youtubeSig(doc, sig)
-		if err != nil {
-			return streamData, fmt.Errorf("couldn't decipher signature: %w", err)
+		// an untransformed n still plays, just slowly
+		throttled := false
+		if hasNParam(streamURL) {
+			descrambled, err := player.descrambleN(ctx, streamURL)
+			if err != nil {
+				throttled = true
+				if throttleErr == nil {
+					throttleErr = err
+				}
+			} else {
+				streamURL = descrambled
+			}
 		}
 
-		// reconstruct url
-		escSig := url.QueryEscape(deciphered)
-		streamURL = fmt.Sprintf("%s&%s=%s", stream_url, sp, escSig)
+		streamFormat := format.toFormat(streamURL)
+		streamFormat.Throttled = throttled
+		data.Formats = append(data.Formats, streamFormat)
 	}
 
-	streamData[0].StreamURL = streamURL
+	if throttleErr != nil {
+		data.Warnings = append(data.Warnings, fmt.Sprintf("couldn't descramble n parameter, formats will be throttled: %v", throttleErr))
+	}
 
-	// video details
-	videoID := urlRx.FindAllStringSubmatch(song_url, 1)[0][1]
+	// default to the best audio, falling back to the best of anything
+	streamFormat, err := data.Select("bestaudio/best")
+	if err != nil {
+		return newExtractError("youtube", StageStreamLookup, ErrUnavailable, "couldn't find a stream: %w", err)
+	}
 
-	streamData[0].Title = jsonData.VideoDetails.Title
+	data.StreamURL = streamFormat.URL
+	data.Protocol = streamFormat.Protocol
 
-	num, err := strconv.ParseFloat(jsonData.VideoDetails.LengthSeconds, 64)
-	if err != nil {
-		return streamData, fmt.Errorf("couldn't parse duration: %w", err)
+	return nil
+}
+
+// point a live stream at its manifest, listing the variants when they can be
+// fetched
+func (c *Client) youtubeLive(ctx context.Context, data *StreamData, streaming youtubeStreamingData) error {
+	data.IsLive = true
+
+	data.StreamURL, data.Protocol = streaming.HlsManifestUrl, ProtocolHLS
+	if data.StreamURL == "" {
+		data.StreamURL, data.Protocol = streaming.DashManifestUrl, ProtocolDASH
+	}
+	if data.StreamURL == "" {
+		return newExtractError("youtube", StageStreamLookup, ErrUnavailable, "live stream has no manifest")
 	}
-	streamData[0].Duration = int(math.Ceil(num))
 
-	streamData[0].ImageURL = fmt.Sprintf("https://i.ytimg.com/vi/%s/maxresdefault.jpg", videoID)
+	formats, err := c.ManifestFormats(ctx, data.StreamURL)
+	if err != nil {
+		data.Warnings = append(data.Warnings, fmt.Sprintf("couldn't list manifest variants: %v", err))
+		return nil
+	}
+	data.Formats = append(data.Formats, formats...)
 
-	return streamData, nil
+	return nil
 }
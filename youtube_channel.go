@@ -0,0 +1,56 @@
+package StreamTool
+
+import (
+	"context"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+var rxYoutubePlaylist = regexp.MustCompile(`https:\/\/(?:www\.|m\.|music\.)?youtube\.com\/playlist\?list=(.+)`)
+
+// places a channel page gives its id
+var rxChannelIDs = []*regexp.Regexp{
+	regexp.MustCompile(`"externalId":"(UC[\w-]{22})"`),
+	regexp.MustCompile(`<meta itemprop="identifier" content="(UC[\w-]{22})">`),
+	regexp.MustCompile(`<link rel="canonical" href="https:\/\/www\.youtube\.com\/channel\/(UC[\w-]{22})">`),
+}
+
+// resolve a channel into the playlist of its uploads
+func (c *Client) parseYoutubeChannel(ctx context.Context, url string, urlRx *regexp.Regexp) (*Result, error) {
+	path := urlRx.FindStringSubmatch(url)[1]
+
+	// handles and custom urls need the channel page to find the id
+	channelID := strings.TrimPrefix(path, "channel/")
+	if channelID == path {
+		resp, err := c.get(ctx, "https://www.youtube.com/"+path)
+		if err != nil {
+			return &Result{Kind: KindPlaylist}, fetchError("youtube", StageFetch, "couldn't fetch channel", err)
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return &Result{Kind: KindPlaylist}, fetchError("youtube", StageFetch, "couldn't read channel", err)
+		}
+
+		channelID = ""
+		for _, rx := range rxChannelIDs {
+			if match := rx.FindSubmatch(body); match != nil {
+				channelID = string(match[1])
+				break
+			}
+		}
+		if channelID == "" {
+			return &Result{Kind: KindPlaylist}, newExtractError("youtube", StageLocateJSON, ErrLayoutChanged, "couldn't find channel id")
+		}
+	}
+
+	if !strings.HasPrefix(channelID, "UC") {
+		return &Result{Kind: KindPlaylist}, newExtractError("youtube", StageDecode, ErrUnsupportedURL, "not a channel id: %s", channelID)
+	}
+
+	// the uploads playlist shares the channel id after its prefix
+	uploadsURL := "https://www.youtube.com/playlist?list=UU" + channelID[2:]
+	return c.parseYoutubePlaylist(ctx, uploadsURL, rxYoutubePlaylist)
+}
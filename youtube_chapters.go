@@ -0,0 +1,70 @@
+package StreamTool
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// chapters youtube marks on the progress bar, listed in the watch page's
+// engagement panels
+type youtubeEngagementPanels struct {
+	EngagementPanels []struct {
+		EngagementPanelSectionListRenderer struct {
+			PanelIdentifier string
+			Content         struct {
+				MacroMarkersListRenderer struct {
+					Contents []struct {
+						MacroMarkersListItemRenderer struct {
+							Title youtubeText
+							OnTap struct {
+								WatchEndpoint struct {
+									StartTimeSeconds int
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// chapters from the macro markers of a watch page's ytInitialData, preferring
+// those the uploader wrote over generated ones
+func youtubeMarkerChapters(page string, duration int) []Chapter {
+	var panels youtubeEngagementPanels
+	if err := json.NewDecoder(strings.NewReader(page)).Decode(&panels); err != nil {
+		return nil
+	}
+
+	var chapters []Chapter
+	for _, panel := range panels.EngagementPanels {
+		renderer := panel.EngagementPanelSectionListRenderer
+		if !strings.Contains(renderer.PanelIdentifier, "macro-markers") {
+			continue
+		}
+
+		var found []Chapter
+		for _, item := range renderer.Content.MacroMarkersListRenderer.Contents {
+			marker := item.MacroMarkersListItemRenderer
+			title := strings.TrimSpace(marker.Title.String())
+			if title == "" {
+				continue
+			}
+			found = append(found, Chapter{Title: title, Start: marker.OnTap.WatchEndpoint.StartTimeSeconds})
+		}
+		if len(found) == 0 {
+			continue
+		}
+
+		chapters = found
+		if strings.Contains(renderer.PanelIdentifier, "description-chapters") {
+			break
+		}
+	}
+
+	if len(chapters) == 0 {
+		return nil
+	}
+	return finishChapters(chapters, duration)
+}
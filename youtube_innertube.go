@@ -0,0 +1,215 @@
+package StreamTool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// YoutubeClient is a client profile used with youtube's innertube api. Each
+// profile is served different formats and restrictions.
+type YoutubeClient struct {
+	// sent as clientName, e.g. "WEB"
+	Name    string
+	Version string
+
+	// numeric id sent in the X-YouTube-Client-Name header
+	ID int
+
+	// replaces the client's UserAgent when not empty
+	UserAgent string
+
+	DeviceMake        string
+	DeviceModel       string
+	OSName            string
+	OSVersion         string
+	AndroidSDKVersion int
+
+	// request as if embedded on another site, which skips some restrictions
+	Embedded bool
+
+	// formats come back ciphered, so the player is needed first for its
+	// signature timestamp
+	Ciphered bool
+}
+
+var (
+	YoutubeWeb = YoutubeClient{
+		Name:     "WEB",
+		Version:  "2.20240726.00.00",
+		ID:       1,
+		Ciphered: true,
+	}
+
+	YoutubeAndroid = YoutubeClient{
+		Name:              "ANDROID",
+		Version:           "19.29.37",
+		ID:                3,
+		UserAgent:         "com.google.android.youtube/19.29.37 (Linux; U; Android 11) gzip",
+		OSName:            "Android",
+		OSVersion:         "11",
+		AndroidSDKVersion: 30,
+	}
+
+	YoutubeIOS = YoutubeClient{
+		Name:        "IOS",
+		Version:     "19.29.1",
+		ID:          5,
+		UserAgent:   "com.google.ios.youtube/19.29.1 (iPhone16,2; U; CPU iOS 17_5_1 like Mac OS X;)",
+		DeviceMake:  "Apple",
+		DeviceModel: "iPhone16,2",
+		OSName:      "iPhone",
+		OSVersion:   "17.5.1.21F90",
+	}
+
+	YoutubeTVEmbedded = YoutubeClient{
+		Name:     "TVHTML5_SIMPLY_EMBEDDED_PLAYER",
+		Version:  "2.0",
+		ID:       85,
+		Embedded: true,
+		Ciphered: true,
+	}
+)
+
+// DefaultYoutubeClients are tried in order when the watch page fails.
+var DefaultYoutubeClients = []YoutubeClient{YoutubeIOS, YoutubeAndroid, YoutubeWeb, YoutubeTVEmbedded}
+
+func (c *Client) youtubeClients() []YoutubeClient {
+	if c.YoutubeClients != nil {
+		return c.YoutubeClients
+	}
+	return DefaultYoutubeClients
+}
+
+type innertubeClientContext struct {
+	ClientName        string `json:"clientName"`
+	ClientVersion     string `json:"clientVersion"`
+	DeviceMake        string `json:"deviceMake,omitempty"`
+	DeviceModel       string `json:"deviceModel,omitempty"`
+	OSName            string `json:"osName,omitempty"`
+	OSVersion         string `json:"osVersion,omitempty"`
+	AndroidSDKVersion int    `json:"androidSdkVersion,omitempty"`
+	Hl                string `json:"hl"`
+}
+
+type innertubeThirdParty struct {
+	EmbedUrl string `json:"embedUrl"`
+}
+
+type innertubeContext struct {
+	Client     innertubeClientContext `json:"client"`
+	ThirdParty *innertubeThirdParty   `json:"thirdParty,omitempty"`
+}
+
+type innertubeContentPlaybackContext struct {
+	SignatureTimestamp int    `json:"signatureTimestamp,omitempty"`
+	Html5Preference    string `json:"html5Preference"`
+}
+
+type innertubePlaybackContext struct {
+	ContentPlaybackContext innertubeContentPlaybackContext `json:"contentPlaybackContext"`
+}
+
+type innertubePlayerRequest struct {
+	Context         innertubeContext         `json:"context"`
+	VideoId         string                   `json:"videoId"`
+	PlaybackContext innertubePlaybackContext `json:"playbackContext"`
+	ContentCheckOk  bool                     `json:"contentCheckOk"`
+	RacyCheckOk     bool                     `json:"racyCheckOk"`
+}
+
+// newInnertubeContext builds the context identifying profile to the api
+func newInnertubeContext(profile YoutubeClient) innertubeContext {
+	itc := innertubeContext{
+		Client: innertubeClientContext{
+			ClientName:        profile.Name,
+			ClientVersion:     profile.Version,
+			DeviceMake:        profile.DeviceMake,
+			DeviceModel:       profile.DeviceModel,
+			OSName:            profile.OSName,
+			OSVersion:         profile.OSVersion,
+			AndroidSDKVersion: profile.AndroidSDKVersion,
+			Hl:                "en",
+		},
+	}
+
+	if profile.Embedded {
+		itc.ThirdParty = &innertubeThirdParty{EmbedUrl: "https://www.youtube.com/"}
+	}
+
+	return itc
+}
+
+// post body to an innertube endpoint as profile and decode the response into v
+func (c *Client) innertube(ctx context.Context, endpoint string, profile YoutubeClient, body interface{}, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return newExtractError("youtube", StageFetch, ErrLayoutChanged, "couldn't encode request: %w", err)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, "https://www.youtube.com/youtubei/v1/"+endpoint+"?prettyPrint=false", bytes.NewReader(data))
+	if err != nil {
+		return newExtractError("youtube", StageFetch, ErrNetwork, "couldn't create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://www.youtube.com")
+	req.Header.Set("X-YouTube-Client-Name", strconv.Itoa(profile.ID))
+	req.Header.Set("X-YouTube-Client-Version", profile.Version)
+	if profile.UserAgent != "" {
+		req.Header.Set("User-Agent", profile.UserAgent)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return fetchError("youtube", StageFetch, "couldn't call "+endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fetchError("youtube", StageFetch, "couldn't read "+endpoint, err)
+	}
+
+	if err := json.Unmarshal(respBody, v); err != nil {
+		return newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't parse json: %w", err)
+	}
+	return nil
+}
+
+// fetch the player response from the innertube api as profile
+func (c *Client) parseYoutubeInnerTube(ctx context.Context, song_url string, videoID string, profile YoutubeClient) ([]StreamData, error) {
+	request := innertubePlayerRequest{
+		Context:        newInnertubeContext(profile),
+		VideoId:        videoID,
+		ContentCheckOk: true,
+		RacyCheckOk:    true,
+	}
+	request.PlaybackContext.ContentPlaybackContext.Html5Preference = "HTML5_PREF_WANTS"
+
+	// ciphered formats must match the player that deciphers them
+	jsUrl := ""
+	if profile.Ciphered {
+		var err error
+		jsUrl, err = c.youtubePlayerURL(ctx)
+		if err != nil {
+			return []StreamData{{URL: song_url}}, err
+		}
+
+		player, err := c.youtubeSig(ctx, jsUrl)
+		if err != nil {
+			return []StreamData{{URL: song_url}}, err
+		}
+		request.PlaybackContext.ContentPlaybackContext.SignatureTimestamp = player.signatureTimestamp
+	}
+
+	var jsonData youtubeJSON
+	if err := c.innertube(ctx, "player", profile, request, &jsonData); err != nil {
+		return []StreamData{{URL: song_url}}, err
+	}
+
+	return c.youtubeStreamData(ctx, song_url, videoID, jsonData, jsUrl)
+}
@@ -0,0 +1,90 @@
+package StreamTool
+
+import (
+	"errors"
+	"strings"
+)
+
+// whether youtube will play the video, and why not
+type youtubePlayabilityStatus struct {
+	Status      string
+	Reason      string
+	ErrorScreen struct {
+		PlayerErrorMessageRenderer struct {
+			Reason    youtubeText
+			Subreason youtubeText
+		}
+
+		// shown instead of the player for members only videos
+		PlayerLegacyDesktopYpcOfferRenderer *struct{}
+		YpcTrailerRenderer                  *struct{}
+	}
+}
+
+// the reason youtube gives, with its subreason when there is one
+func (s youtubePlayabilityStatus) reason() string {
+	message := s.ErrorScreen.PlayerErrorMessageRenderer
+	reason := s.Reason
+	if reason == "" {
+		reason = message.Reason.String()
+	}
+
+	if subreason := message.Subreason.String(); subreason != "" && subreason != reason {
+		if reason == "" {
+			return subreason
+		}
+		return reason + ": " + subreason
+	}
+	return reason
+}
+
+// map the status to an error, nil when the video can be played
+func (s youtubePlayabilityStatus) err() error {
+	if s.Status == "" || s.Status == "OK" {
+		return nil
+	}
+
+	reason := s.reason()
+	lower := strings.ToLower(reason)
+
+	var kind error
+	switch {
+	case s.Status == "AGE_CHECK_REQUIRED" || s.Status == "AGE_VERIFICATION_REQUIRED" ||
+		strings.Contains(lower, "confirm your age") || strings.Contains(lower, "age-restricted"):
+		kind = ErrAgeRestricted
+	case s.ErrorScreen.PlayerLegacyDesktopYpcOfferRenderer != nil || s.ErrorScreen.YpcTrailerRenderer != nil ||
+		strings.Contains(lower, "members"):
+		kind = ErrMembersOnly
+	case strings.Contains(lower, "private"):
+		kind = ErrPrivate
+	case strings.Contains(lower, "country") || strings.Contains(lower, "region"):
+		kind = ErrGeoBlocked
+	case strings.Contains(lower, "not a bot"):
+		kind = ErrRateLimited
+	default:
+		kind = ErrUnavailable
+	}
+
+	if reason == "" {
+		reason = "video is unplayable"
+	}
+
+	return &ExtractError{
+		Provider: "youtube",
+		Stage:    StagePlayability,
+		Kind:     kind,
+		Err:      errors.New(strings.ToLower(s.Status) + ": " + reason),
+		Reason:   reason,
+	}
+}
+
+// whether another client could still play a video that failed with err,
+// which isn't the case for private, removed or blocked videos
+func youtubeRetryable(err error) bool {
+	var extractErr *ExtractError
+	if !errors.As(err, &extractErr) || extractErr.Stage != StagePlayability {
+		return true
+	}
+
+	return extractErr.Kind == ErrAgeRestricted || extractErr.Kind == ErrRateLimited
+}
@@ -0,0 +1,103 @@
+package StreamTool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestYoutubePlayabilityErr(t *testing.T) {
+	tests := []struct {
+		status string
+		kind   error
+		reason string
+	}{
+		{`{}`, nil, ""},
+		{`{"status": "OK"}`, nil, ""},
+		{`{"status": "AGE_CHECK_REQUIRED"}`, ErrAgeRestricted, "video is unplayable"},
+		{`{"status": "LOGIN_REQUIRED", "reason": "Sign in to confirm your age"}`, ErrAgeRestricted, "Sign in to confirm your age"},
+		{`{"status": "LOGIN_REQUIRED", "reason": "This video is private"}`, ErrPrivate, "This video is private"},
+		{`{"status": "LOGIN_REQUIRED", "reason": "Sign in to confirm you’re not a bot"}`, ErrRateLimited, "Sign in to confirm you’re not a bot"},
+		{`{"status": "UNPLAYABLE", "errorScreen": {"playerErrorMessageRenderer": {"reason": {"simpleText": "Video unavailable"},
+			"subreason": {"runs": [{"text": "The uploader has not made this video available in your country"}]}}}}`,
+			ErrGeoBlocked, "Video unavailable: The uploader has not made this video available in your country"},
+		{`{"status": "UNPLAYABLE", "reason": "Join this channel to get access", "errorScreen": {"playerLegacyDesktopYpcOfferRenderer": {}}}`, ErrMembersOnly, "Join this channel to get access"},
+		{`{"status": "UNPLAYABLE", "errorScreen": {"ypcTrailerRenderer": {}}}`, ErrMembersOnly, "video is unplayable"},
+		{`{"status": "ERROR", "reason": "Video unavailable"}`, ErrUnavailable, "Video unavailable"},
+		{`{"status": "ERROR", "errorScreen": {"playerErrorMessageRenderer": {"subreason": {"simpleText": "This video has been removed"}}}}`, ErrUnavailable, "This video has been removed"},
+	}
+
+	for _, test := range tests {
+		var status youtubePlayabilityStatus
+		if err := json.Unmarshal([]byte(test.status), &status); err != nil {
+			t.Fatal(err)
+		}
+
+		err := status.err()
+		if test.kind == nil {
+			if err != nil {
+				t.Errorf("%s: err = %v, want nil", test.status, err)
+			}
+			continue
+		}
+
+		var extractErr *ExtractError
+		if !errors.As(err, &extractErr) || extractErr.Stage != StagePlayability {
+			t.Errorf("%s: err = %v, want a playability ExtractError", test.status, err)
+			continue
+		}
+		if extractErr.Kind != test.kind || extractErr.Reason != test.reason {
+			t.Errorf("%s: kind %v, reason %q, want %v, %q", test.status, extractErr.Kind, extractErr.Reason, test.kind, test.reason)
+		}
+	}
+}
+
+func TestYoutubeRetryable(t *testing.T) {
+	playability := func(kind error) error {
+		return &ExtractError{Provider: "youtube", Stage: StagePlayability, Kind: kind, Err: errors.New("x")}
+	}
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{playability(ErrAgeRestricted), true},
+		{playability(ErrRateLimited), true},
+		{playability(ErrPrivate), false},
+		{playability(ErrGeoBlocked), false},
+		{playability(ErrMembersOnly), false},
+		{playability(ErrUnavailable), false},
+		{fmt.Errorf("client failed: %w", playability(ErrPrivate)), false},
+		{newExtractError("youtube", StageFetch, ErrUnavailable, "404"), true},
+		{newExtractError("youtube", StageDecode, ErrLayoutChanged, "bad json"), true},
+		{errors.New("network"), true},
+	}
+
+	for _, test := range tests {
+		if got := youtubeRetryable(test.err); got != test.want {
+			t.Errorf("youtubeRetryable(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestYoutubeWebpageLanguage(t *testing.T) {
+	// reasons follow hl, which wins over Accept-Language
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := "Dieses Video ist privat"
+		if r.URL.Query().Get("hl") == "en" {
+			reason = "This video is private"
+		}
+		fmt.Fprintf(w, `<html><script>var ytInitialPlayerResponse = {"playabilityStatus": {"status": "LOGIN_REQUIRED", "reason": %q}};</script></html>`, reason)
+	}))
+	defer srv.Close()
+
+	c := &Client{AcceptLanguage: "de-DE", BaseURLs: map[string]string{"www.youtube.com": srv.URL}}
+	_, err := c.parseYoutubeWebpage(context.Background(), "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ")
+	if !errors.Is(err, ErrPrivate) {
+		t.Errorf("err = %v, want ErrPrivate", err)
+	}
+}
@@ -0,0 +1,390 @@
+package StreamTool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DougTy/StreamTool/internal/jsinterp"
+)
+
+// the parts of the player js needed to unlock stream urls
+type youtubePlayer struct {
+	signatureTimestamp int
+
+	sigFunc *youtubeJSFunc
+	sigErr  error
+
+	nFunc *youtubeJSFunc
+	nErr  error
+}
+
+// what's derived from a player js, cached under its url
+type youtubePlayerPlan struct {
+	// sent to the api so it returns formats this player can decipher
+	SignatureTimestamp int
+
+	SigName string
+	SigCode string
+	SigErr  string
+
+	NName string
+	NCode string
+	NErr  string
+}
+
+// bump when the plan or how it's derived changes, so stale entries are skipped
+const youtubePlayerCacheKey = "youtube:player:v2:"
+
+// player js url linked from the watch page
+var rxJsUrl = regexp.MustCompile(`"jsUrl":"(.+?)"`)
+
+// fetch the player js and extract the signature and n transforms, reusing the
+// cached plan when the player hasn't changed. The current player is looked up
+// when jsUrl is empty
+func (c *Client) youtubeSig(ctx context.Context, jsUrl string) (*youtubePlayer, error) {
+	if jsUrl == "" {
+		var err error
+		jsUrl, err = c.youtubePlayerURL(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the player rarely changes, so most calls skip fetching it
+	key := youtubePlayerCacheKey + jsUrl
+	if plan, ok := c.cachedPlayerPlan(key); ok {
+		return plan.load(ctx), nil
+	}
+
+	mu := youtubePlayerLock(jsUrl)
+	mu.Lock()
+	defer mu.Unlock()
+
+	// someone else may have derived it while we waited
+	if plan, ok := c.cachedPlayerPlan(key); ok {
+		return plan.load(ctx), nil
+	}
+
+	plan, err := c.youtubePlayerPlan(ctx, jsUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(plan); err == nil {
+		c.cache().Set(key, data)
+	}
+
+	return plan.load(ctx), nil
+}
+
+var (
+	youtubePlayerMu    sync.Mutex
+	youtubePlayerLocks = map[string]*sync.Mutex{}
+)
+
+// held while deriving the plan of jsUrl, so concurrent lookups of the same
+// player share one
+func youtubePlayerLock(jsUrl string) *sync.Mutex {
+	youtubePlayerMu.Lock()
+	defer youtubePlayerMu.Unlock()
+
+	mu, ok := youtubePlayerLocks[jsUrl]
+	if !ok {
+		mu = &sync.Mutex{}
+		youtubePlayerLocks[jsUrl] = mu
+	}
+	return mu
+}
+
+func (c *Client) cachedPlayerPlan(key string) (youtubePlayerPlan, bool) {
+	var plan youtubePlayerPlan
+	cached, ok := c.cache().Get(key)
+	if !ok || json.Unmarshal(cached, &plan) != nil {
+		return plan, false
+	}
+	return plan, true
+}
+
+// the current player only changes every few days, so its url is reused for
+// a while instead of asking for it for every video and api client
+const (
+	youtubePlayerURLCacheKey = "youtube:player_url"
+	youtubePlayerURLMaxAge   = 10 * time.Minute
+)
+
+type youtubeCachedPlayerURL struct {
+	URL  string
+	Time time.Time
+}
+
+// note jsUrl as the current player
+func (c *Client) setYoutubePlayerURL(jsUrl string) {
+	if data, err := json.Marshal(youtubeCachedPlayerURL{jsUrl, time.Now()}); err == nil {
+		c.cache().Set(youtubePlayerURLCacheKey, data)
+	}
+}
+
+// find the current player js without a watch page
+func (c *Client) youtubePlayerURL(ctx context.Context) (string, error) {
+	var cached youtubeCachedPlayerURL
+	if data, ok := c.cache().Get(youtubePlayerURLCacheKey); ok && json.Unmarshal(data, &cached) == nil &&
+		cached.URL != "" && time.Since(cached.Time) < youtubePlayerURLMaxAge {
+		return cached.URL, nil
+	}
+
+	resp, err := c.get(ctx, "https://www.youtube.com/iframe_api")
+	if err != nil {
+		return "", fetchError("youtube", StageDecipher, "couldn't fetch iframe api", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fetchError("youtube", StageDecipher, "couldn't read iframe api", err)
+	}
+
+	match := regexp.MustCompile(`player\\?/([0-9a-fA-F]{8})\\?/`).FindStringSubmatch(string(body))
+	if match == nil {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't match player id")
+	}
+
+	jsUrl := fmt.Sprintf("/s/player/%s/player_ias.vflset/en_US/base.js", match[1])
+	c.setYoutubePlayerURL(jsUrl)
+	return jsUrl, nil
+}
+
+// fetch the player js and derive its plan
+func (c *Client) youtubePlayerPlan(ctx context.Context, jsUrl string) (youtubePlayerPlan, error) {
+	var plan youtubePlayerPlan
+
+	// fetch player js
+	resp, err := c.get(ctx, "https://www.youtube.com"+jsUrl)
+	if err != nil {
+		return plan, fetchError("youtube", StageDecipher, "couldn't fetch player js", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return plan, fetchError("youtube", StageDecipher, "couldn't read player js", err)
+	}
+	playerjs := string(body)
+
+	if match := regexp.MustCompile(`(?:signatureTimestamp|sts)\s*:\s*(\d{5})`).FindStringSubmatch(playerjs); match != nil {
+		plan.SignatureTimestamp, _ = strconv.Atoi(match[1])
+	}
+
+	// either can fail without the other being needed
+	plan.SigName, plan.SigCode, err = youtubeSigCode(playerjs)
+	plan.SigErr = planError(err)
+	plan.NName, plan.NCode, err = youtubeNCode(playerjs)
+	plan.NErr = planError(err)
+
+	return plan, nil
+}
+
+// message of an error stored in a plan, without the provider and stage
+func planError(err error) string {
+	var extractErr *ExtractError
+	if errors.As(err, &extractErr) {
+		return extractErr.Err.Error()
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// set up the functions of a plan for use
+func (plan youtubePlayerPlan) load(ctx context.Context) *youtubePlayer {
+	player := &youtubePlayer{signatureTimestamp: plan.SignatureTimestamp}
+
+	if plan.SigErr != "" {
+		player.sigErr = newExtractError("youtube", StageDecipher, ErrLayoutChanged, "%s", plan.SigErr)
+	} else {
+		player.sigFunc, player.sigErr = loadYoutubeJSFunc(ctx, plan.SigName, plan.SigCode)
+	}
+
+	if plan.NErr != "" {
+		player.nErr = newExtractError("youtube", StageDecipher, ErrLayoutChanged, "%s", plan.NErr)
+	} else {
+		player.nFunc, player.nErr = loadYoutubeJSFunc(ctx, plan.NName, plan.NCode)
+	}
+
+	return player
+}
+
+// transform the n parameter of a stream url, if the player allowed it
+func (p *youtubePlayer) descrambleN(ctx context.Context, streamURL string) (string, error) {
+	if p.nErr != nil {
+		return "", p.nErr
+	}
+
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't parse stream url: %w", err)
+	}
+
+	query := u.Query()
+	n := query.Get("n")
+	if n == "" {
+		return streamURL, nil
+	}
+
+	result, err := p.nFunc.call(ctx, n)
+	if err != nil {
+		return "", err
+	}
+
+	// the transform returns its input marked up when it throws
+	if strings.HasPrefix(result, youtubeNExcept) || strings.HasSuffix(result, n) {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "n function failed on %s", n)
+	}
+
+	query.Set("n", result)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// reports whether a stream url carries an n parameter
+func hasNParam(streamURL string) bool {
+	u, err := url.Parse(streamURL)
+	return err == nil && u.Query().Get("n") != ""
+}
+
+// function taken from the player js, run in its own interpreter
+type youtubeJSFunc struct {
+	name    string
+	vm      *jsinterp.VM
+	results map[string]string
+}
+
+// extract a function, along with anything it depends on, from the player
+func extractYoutubeJSFunc(playerjs string, name string) (string, string, error) {
+	code, err := jsinterp.Extract(playerjs, name)
+	if err != nil {
+		return "", "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't extract %s: %w", name, err)
+	}
+	return name, code, nil
+}
+
+// run the code of an extracted function in its own interpreter
+func loadYoutubeJSFunc(ctx context.Context, name string, code string) (*youtubeJSFunc, error) {
+	vm := jsinterp.New()
+	if _, err := vm.Run(ctx, code); err != nil {
+		return nil, newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't load %s: %w", name, err)
+	}
+
+	return &youtubeJSFunc{name: name, vm: vm, results: map[string]string{}}, nil
+}
+
+// run the function over arg, formats often share the same input
+func (f *youtubeJSFunc) call(ctx context.Context, arg string) (string, error) {
+	if result, ok := f.results[arg]; ok {
+		return result, nil
+	}
+
+	value, err := f.vm.Call(ctx, f.name, arg)
+	if err != nil {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't run %s: %w", f.name, err)
+	}
+
+	result := jsinterp.ToString(value)
+	f.results[arg] = result
+	return result, nil
+}
+
+// ways the player passes the signature to its cipher function
+var rxSigFuncCalls = []*regexp.Regexp{
+	regexp.MustCompile(`(?:^|[^a-zA-Z0-9_$])([a-zA-Z0-9_$]{2,})\s*=\s*function\(\s*[a-zA-Z0-9_$]+\s*\)\s*\{\s*[a-zA-Z0-9_$]+\s*=\s*[a-zA-Z0-9_$]+\.split\(\s*""\s*\)`),
+	regexp.MustCompile(`\b[cs]\s*&&\s*[adf]\.set\([^,]+\s*,\s*encodeURIComponent\s*\(\s*([a-zA-Z0-9_$]+)\(`),
+	regexp.MustCompile(`\b[a-zA-Z0-9]+\s*&&\s*[a-zA-Z0-9]+\.set\([^,]+\s*,\s*encodeURIComponent\s*\(\s*([a-zA-Z0-9_$]+)\(`),
+	regexp.MustCompile(`\bm=([a-zA-Z0-9_$]{2,})\(decodeURIComponent\(h\.s\)\)`),
+	regexp.MustCompile(`[a-zA-Z0-9_$]+&&\([a-zA-Z0-9_$]+=([a-zA-Z0-9_$]{2,})\(decodeURIComponent\([a-zA-Z0-9_$]+\)\)`),
+}
+
+// extract the signature cipher function from the player
+func youtubeSigCode(playerjs string) (string, string, error) {
+	for _, rx := range rxSigFuncCalls {
+		if match := rx.FindStringSubmatch(playerjs); match != nil {
+			return extractYoutubeJSFunc(playerjs, match[1])
+		}
+	}
+
+	return "", "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't find signature function")
+}
+
+// ways the player passes the n parameter to its transform function
+var rxNFuncCalls = []*regexp.Regexp{
+	regexp.MustCompile(`\.get\("n"\)\)&&\(b=([a-zA-Z0-9_$]+)(?:\[(\d+)\])?\([a-zA-Z0-9]\)`),
+	regexp.MustCompile(`b=String\.fromCharCode\(110\),c=a\.get\(b\)\)&&\(c=([a-zA-Z0-9_$]+)(?:\[(\d+)\])?\([a-zA-Z0-9]\)`),
+	regexp.MustCompile(`&&\(b="nn"\[\+[a-zA-Z0-9_$.]+\],c=a\.get\(b\)\)&&\(c=([a-zA-Z0-9_$]+)(?:\[(\d+)\])?\([a-zA-Z0-9]\)`),
+}
+
+// the n transform returns this prefix when it throws
+const youtubeNExcept = "enhanced_except_"
+
+// early return the n transform uses to detect being run outside the player
+var rxNFuncGuard = regexp.MustCompile(`;\s*if\s*\(\s*typeof\s+[a-zA-Z0-9_$]+\s*===?\s*(?:"undefined"|'undefined'|[a-zA-Z0-9_$]+\[\d+\])\s*\)\s*return\s+[a-zA-Z0-9_$]+;`)
+
+// find the name of the function that transforms the n parameter
+func youtubeNFuncName(playerjs string) (string, error) {
+	for _, rx := range rxNFuncCalls {
+		match := rx.FindStringSubmatch(playerjs)
+		if match == nil {
+			continue
+		}
+
+		name := match[1]
+		if match[2] == "" {
+			return name, nil
+		}
+
+		// the call goes through an array of functions
+		index, _ := strconv.Atoi(match[2])
+		rxArray := regexp.MustCompile(`var ` + regexp.QuoteMeta(name) + `\s*=\s*\[([^\]]+)\]`).FindStringSubmatch(playerjs)
+		if rxArray == nil {
+			return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't find n function array %s", name)
+		}
+
+		elems := strings.Split(rxArray[1], ",")
+		if index >= len(elems) {
+			return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "n function index %d out of range", index)
+		}
+		return strings.TrimSpace(elems[index]), nil
+	}
+
+	// fall back to the function returning the exception marker
+	marker := strings.Index(playerjs, `"`+youtubeNExcept)
+	if marker == -1 {
+		marker = strings.Index(playerjs, `"_w8_"`)
+	}
+	if marker == -1 {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't find n function")
+	}
+
+	defs := regexp.MustCompile(`([a-zA-Z0-9_$]+)=function\(`).FindAllStringSubmatch(playerjs[:marker], -1)
+	if len(defs) == 0 {
+		return "", newExtractError("youtube", StageDecipher, ErrLayoutChanged, "couldn't find n function")
+	}
+	return defs[len(defs)-1][1], nil
+}
+
+// extract the n transform from the player
+func youtubeNCode(playerjs string) (string, string, error) {
+	name, err := youtubeNFuncName(playerjs)
+	if err != nil {
+		return "", "", err
+	}
+
+	return extractYoutubeJSFunc(rxNFuncGuard.ReplaceAllString(playerjs, ";"), name)
+}
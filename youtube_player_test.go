@@ -0,0 +1,101 @@
+package StreamTool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestYoutubeSigCoalesced(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		// give the other callers time to pile up
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, `var cfg={signatureTimestamp:19834};`)
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		Cache:    NewMemoryCache(),
+		BaseURLs: map[string]string{"www.youtube.com": srv.URL},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			player, err := c.youtubeSig(context.Background(), "/s/player/coalesce/base.js")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if player.signatureTimestamp != 19834 {
+				t.Errorf("signatureTimestamp = %d, want 19834", player.signatureTimestamp)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if fetches := atomic.LoadInt32(&fetches); fetches != 1 {
+		t.Errorf("player js fetched %d times, want once", fetches)
+	}
+}
+
+func TestYoutubePlayerURLReused(t *testing.T) {
+	var iframeFetches, playerCalls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/iframe_api":
+			atomic.AddInt32(&iframeFetches, 1)
+			fmt.Fprint(w, `var scriptUrl = 'https:\/\/www.youtube.com\/s\/player\/0123abcd\/www-widgetapi.vflset\/www-widgetapi.js';`)
+		case r.URL.Path == "/s/player/0123abcd/player_ias.vflset/en_US/base.js":
+			fmt.Fprint(w, `var cfg={signatureTimestamp:19834};`)
+		case r.URL.Path == "/youtubei/v1/player":
+			// retryable, so every client is tried
+			atomic.AddInt32(&playerCalls, 1)
+			fmt.Fprint(w, `{"playabilityStatus": {"status": "LOGIN_REQUIRED", "reason": "Sign in to confirm you're not a bot"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		Cache:              NewMemoryCache(),
+		BaseURLs:           map[string]string{"www.youtube.com": srv.URL},
+		YoutubeClients:     []YoutubeClient{YoutubeWeb, YoutubeTVEmbedded},
+		YoutubeSkipWebpage: true,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.youtubeVideo(context.Background(), "https://youtu.be/dQw4w9WgXcQ", "dQw4w9WgXcQ"); !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("err = %v, want ErrRateLimited", err)
+		}
+	}
+
+	if calls := atomic.LoadInt32(&playerCalls); calls != 4 {
+		t.Errorf("%d player requests, want one per client and video", calls)
+	}
+	if fetches := atomic.LoadInt32(&iframeFetches); fetches != 1 {
+		t.Errorf("iframe api fetched %d times, want once", fetches)
+	}
+
+	// once it's stale the current player is looked up again
+	data, _ := json.Marshal(youtubeCachedPlayerURL{"/s/player/0123abcd/player_ias.vflset/en_US/base.js", time.Now().Add(-youtubePlayerURLMaxAge)})
+	c.Cache.Set(youtubePlayerURLCacheKey, data)
+	if _, err := c.youtubePlayerURL(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fetches := atomic.LoadInt32(&iframeFetches); fetches != 2 {
+		t.Errorf("iframe api fetched %d times after the url went stale, want twice", fetches)
+	}
+}
@@ -1,9 +1,13 @@
 package StreamTool
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	neturl "net/url"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"golang.org/x/net/html"
@@ -23,26 +27,42 @@ func findPlaylistJSON(node *html.Node) bool {
 	return false
 }
 
-func parseYoutubePlaylist(url string, urlRx *regexp.Regexp) ([]StreamData, error) {
-	var streamData []StreamData
+type youtubeBrowseRequest struct {
+	Context      innertubeContext `json:"context"`
+	Continuation string           `json:"continuation"`
+}
+
+func (c *Client) parseYoutubePlaylist(ctx context.Context, url string, urlRx *regexp.Regexp) (*Result, error) {
+	result := &Result{Kind: KindPlaylist}
+
+	search := strings.Contains(url, "search_query=")
+	if search {
+		result.Kind = KindSearch
+	}
+
+	// music links share the regular playlist page
+	pageURL := url
+	if !search {
+		pageURL = "https://www.youtube.com/playlist?list=" + youtubeListID(url, urlRx)
+	}
 
 	// fetch url
-	resp, err := netClient.Get(url)
+	resp, err := c.get(ctx, pageURL)
 	if err != nil {
-		return streamData, fmt.Errorf("couldn't fetch url: %w", err)
+		return result, fetchError("youtube", StageFetch, "couldn't fetch url", err)
 	}
 	defer resp.Body.Close()
 
 	// parse html
 	doc, err := html.Parse(resp.Body)
 	if err != nil {
-		return streamData, errors.New("couldn't find playlist json")
+		return result, newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't parse html: %w", err)
 	}
 
 	// find and parse manifest
 	node := findNode(doc, findPlaylistJSON)
 	if node == nil {
-		return streamData, errors.New("couldn't find playlist json")
+		return result, newExtractError("youtube", StageLocateJSON, ErrLayoutChanged, "couldn't find playlist json")
 	}
 
 	playlistJSON := node.Data
@@ -51,54 +71,417 @@ func parseYoutubePlaylist(url string, urlRx *regexp.Regexp) ([]StreamData, error
 	firstBracket := strings.Index(playlistJSON, "{")
 	playlistJSON = playlistJSON[firstBracket:]
 
-	// remove trailing ;
-	if strings.HasSuffix(playlistJSON, ";") {
-		playlistJSON = playlistJSON[:len(playlistJSON)-1]
+	// only the object itself is decoded, so anything trailing it is ignored
+	var page json.RawMessage
+	if err := json.NewDecoder(strings.NewReader(playlistJSON)).Decode(&page); err != nil {
+		return result, newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't parse json: %w", err)
 	}
 
-	// get video titles
-	rawVideoTitles := regexp.MustCompile(`"title":\s*?{\s*?"runs":\s*?\[{\s*?"text":\s*?"(.+?)"\s*?}\],\s*?"accessibility"`).FindAllStringSubmatch(playlistJSON, -1)
-	if len(rawVideoTitles) == 0 {
-		return streamData, errors.New("couldn't match video titles")
+	setVideoIds := map[string]bool{}
+	items, err := youtubePlaylistPage(page, setVideoIds)
+	if err != nil {
+		return result, err
+	}
+	if len(items.entries) == 0 && items.skipped == 0 {
+		return result, newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't find any videos")
 	}
+	result.Entries = items.entries
 
-	// get video urls
-	rawVideoURLs := regexp.MustCompile(`"videoIds":\s*?\["(.+?)"\]`).FindAllStringSubmatch(playlistJSON, -1)
-	if len(rawVideoURLs) == 0 {
-		return streamData, errors.New("couldn't match video URLs")
+	if search {
+		result.Playlist = &PlaylistInfo{URL: url}
+		if u, err := neturl.Parse(url); err == nil {
+			result.Playlist.Title = u.Query().Get("search_query")
+		}
+
+		// search results go on practically forever, so only the first page is used
+		result.Entries = limitPlaylist(result.Entries, c.MaxPlaylistItems)
+		result.Playlist.Count = len(result.Entries)
+		return result, nil
 	}
 
-	// transform video titles into an array
-	var videoTitles []string
-	for _, v := range rawVideoTitles {
-		videoTitles = append(videoTitles, v[1])
+	var info youtubePlaylistJSON
+	if err := json.Unmarshal(page, &info); err != nil {
+		return result, newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't parse playlist json: %w", err)
 	}
+	result.Playlist = info.toPlaylistInfo(url)
+	if result.Playlist.ID == "" {
+		result.Playlist.ID = youtubeListID(url, urlRx)
+	}
+
+	// follow the continuation tokens until the playlist runs out
+	seen := map[string]bool{}
+	for c.MaxPlaylistItems <= 0 || len(result.Entries) < c.MaxPlaylistItems {
+		if items.continuation == "" || seen[items.continuation] {
+			break
+		}
+		seen[items.continuation] = true
+
+		request := youtubeBrowseRequest{
+			Context:      newInnertubeContext(YoutubeWeb),
+			Continuation: items.continuation,
+		}
 
-	// transform video URLs into an array and remove duplicates
-	var lastURL string
-	var videoURLs []string
-	for _, v := range rawVideoURLs {
-		if v[1] != lastURL {
-			videoURLs = append(videoURLs, v[1])
+		var continuation json.RawMessage
+		if err := c.innertube(ctx, "browse", YoutubeWeb, request, &continuation); err != nil {
+			return result, err
+		}
+
+		next, err := youtubePlaylistPage(continuation, setVideoIds)
+		if err != nil {
+			return result, err
+		}
+
+		result.Entries = append(result.Entries, next.entries...)
+		items.continuation = next.continuation
+	}
+
+	result.Entries = limitPlaylist(result.Entries, c.MaxPlaylistItems)
+	if result.Playlist.Count == 0 {
+		result.Playlist.Count = len(result.Entries)
+	}
+
+	return result, nil
+}
+
+// the parts of ytInitialData describing the playlist itself
+type youtubePlaylistJSON struct {
+	Metadata struct {
+		PlaylistMetadataRenderer struct {
+			Title       string
+			Description string
+		}
+	}
+	Microformat struct {
+		MicroformatDataRenderer struct {
+			Thumbnail youtubeThumbnails
+		}
+	}
+	Header struct {
+		PlaylistHeaderRenderer struct {
+			PlaylistId    string
+			OwnerText     youtubeText
+			NumVideosText youtubeText
+		}
+	}
+	Sidebar struct {
+		PlaylistSidebarRenderer struct {
+			Items []struct {
+				PlaylistSidebarPrimaryInfoRenderer struct {
+					Stats []youtubeText
+				}
+				PlaylistSidebarSecondaryInfoRenderer struct {
+					VideoOwner struct {
+						VideoOwnerRenderer struct {
+							Title youtubeText
+						}
+					}
+				}
+			}
 		}
-		lastURL = v[1]
 	}
+}
+
+func (p youtubePlaylistJSON) toPlaylistInfo(url string) *PlaylistInfo {
+	header := p.Header.PlaylistHeaderRenderer
+	info := &PlaylistInfo{
+		ID:          header.PlaylistId,
+		URL:         url,
+		Title:       p.Metadata.PlaylistMetadataRenderer.Title,
+		Description: p.Metadata.PlaylistMetadataRenderer.Description,
+		ImageURL:    p.Microformat.MicroformatDataRenderer.Thumbnail.best(),
+	}
+
+	owner := header.OwnerText
+	count := header.NumVideosText
+
+	// newer layouts only fill in the sidebar
+	for _, item := range p.Sidebar.PlaylistSidebarRenderer.Items {
+		if owner.String() == "" {
+			owner = item.PlaylistSidebarSecondaryInfoRenderer.VideoOwner.VideoOwnerRenderer.Title
+		}
+
+		stats := item.PlaylistSidebarPrimaryInfoRenderer.Stats
+		if count.String() == "" && len(stats) > 0 {
+			count = stats[0]
+		}
+	}
+
+	info.Owner = owner.String()
+	info.OwnerID = owner.browseID()
+	info.Count = parseCount(count.String())
+
+	return info
+}
+
+// number in text such as "1,234 videos", 0 when there is none
+func parseCount(str string) int {
+	fields := strings.Fields(str)
+	if len(fields) == 0 {
+		return 0
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, fields[0])
+
+	count, _ := strconv.Atoi(digits)
+	return count
+}
+
+// playlist id from the list parameter matched by urlRx
+func youtubeListID(url string, urlRx *regexp.Regexp) string {
+	listID := urlRx.FindStringSubmatch(url)[1]
+	if end := strings.IndexAny(listID, "&#"); end != -1 {
+		listID = listID[:end]
+	}
+	return listID
+}
+
+// cut entries down to at most max, when max is set
+func limitPlaylist(entries []StreamData, max int) []StreamData {
+	if max > 0 && len(entries) > max {
+		return entries[:max]
+	}
+	return entries
+}
+
+// text as youtube sends it, either plain or split into runs
+type youtubeText struct {
+	SimpleText string
+	Runs       []struct {
+		Text               string
+		NavigationEndpoint struct {
+			BrowseEndpoint struct {
+				BrowseId string
+			}
+		}
+	}
+}
+
+func (t youtubeText) String() string {
+	if t.SimpleText != "" {
+		return t.SimpleText
+	}
+
+	var sb strings.Builder
+	for _, run := range t.Runs {
+		sb.WriteString(run.Text)
+	}
+	return sb.String()
+}
+
+// channel id linked from the text, if any
+func (t youtubeText) browseID() string {
+	for _, run := range t.Runs {
+		if id := run.NavigationEndpoint.BrowseEndpoint.BrowseId; id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+type youtubeThumbnails struct {
+	Thumbnails []struct {
+		Url    string
+		Width  int
+		Height int
+	}
+}
+
+// url of the largest thumbnail
+func (t youtubeThumbnails) best() string {
+	best := ""
+	size := -1
+	for _, thumb := range t.Thumbnails {
+		if thumb.Width*thumb.Height > size {
+			best = thumb.Url
+			size = thumb.Width * thumb.Height
+		}
+	}
+	return best
+}
+
+// covers both playlistVideoRenderer and videoRenderer
+type youtubeVideoRenderer struct {
+	VideoId         string
+	SetVideoId      string
+	Title           youtubeText
+	LengthText      youtubeText
+	LengthSeconds   string
+	Thumbnail       youtubeThumbnails
+	OwnerText       youtubeText
+	ShortBylineText youtubeText
+	IsPlayable      *bool
+}
+
+// convert to a StreamData, the streams are resolved later
+func (r youtubeVideoRenderer) toStreamData() StreamData {
+	entry := StreamData{
+		Title:    r.Title.String(),
+		URL:      fmt.Sprintf("https://www.youtube.com/watch?v=%s", r.VideoId),
+		ImageURL: r.Thumbnail.best(),
+	}
+
+	if seconds, err := strconv.Atoi(r.LengthSeconds); err == nil {
+		entry.Duration = seconds
+	} else {
+		entry.Duration = parseClockDuration(r.LengthText.String())
+	}
+
+	owner := r.OwnerText
+	if len(owner.Runs) == 0 && owner.SimpleText == "" {
+		owner = r.ShortBylineText
+	}
+	entry.Uploader = owner.String()
+	entry.UploaderID = owner.browseID()
+
+	return entry
+}
+
+// seconds in a duration such as "1:02:03", 0 when it can't be read
+func parseClockDuration(str string) int {
+	seconds := 0
+	for _, part := range strings.Split(str, ":") {
+		num, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + num
+	}
+	return seconds
+}
+
+type youtubeContinuationItem struct {
+	ContinuationEndpoint struct {
+		ContinuationCommand struct {
+			Token string
+		}
+	}
+}
+
+// renderers holding ads, which are never entries
+var youtubeAdRenderers = map[string]bool{
+	"adSlotRenderer":              true,
+	"promotedVideoRenderer":       true,
+	"promotedSparklesWebRenderer": true,
+	"searchPyvRenderer":           true,
+}
+
+// what's found on a page of playlist or search results
+type youtubeItems struct {
+	entries []StreamData
+	skipped int
+
+	// token for the next page of the list holding the videos
+	continuation string
+
+	// playlist item ids, which unlike video ids are unique within a playlist
+	setVideoIds map[string]bool
+}
+
+// decode the entries of a page of ytInitialData or a continuation of it,
+// sharing setVideoIds across the pages of a playlist since an item can show
+// up on either side of a page boundary
+func youtubePlaylistPage(page json.RawMessage, setVideoIds map[string]bool) (*youtubeItems, error) {
+	items := &youtubeItems{setVideoIds: setVideoIds}
+	if err := items.walk(json.NewDecoder(bytes.NewReader(page)), 0); err != nil {
+		return items, newExtractError("youtube", StageDecode, ErrLayoutChanged, "couldn't parse json: %w", err)
+	}
+	return items, nil
+}
+
+// videos seen so far, playable or not
+func (items *youtubeItems) found() int {
+	return len(items.entries) + items.skipped
+}
+
+// visit every renderer in document order, decoding the page in a single pass.
+// listStart is how many videos had been found when the innermost enclosing
+// list began, so a continuation is only taken from a list that held videos
+func (items *youtubeItems) walk(dec *json.Decoder, listStart int) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return nil
+	}
+
+	switch delim {
+	case '[':
+		start := items.found()
+		for dec.More() {
+			if err := items.walk(dec, start); err != nil {
+				return err
+			}
+		}
+
+	case '{':
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := token.(string)
+
+			switch {
+			case key == "playlistVideoRenderer" || key == "videoRenderer":
+				var renderer youtubeVideoRenderer
+				if err := dec.Decode(&renderer); err != nil {
+					return err
+				}
+				items.addVideo(renderer)
+
+			case key == "continuationItemRenderer":
+				var item youtubeContinuationItem
+				if err := dec.Decode(&item); err != nil {
+					return err
+				}
+
+				token := item.ContinuationEndpoint.ContinuationCommand.Token
+				if token != "" && items.continuation == "" && items.found() > listStart {
+					items.continuation = token
+				}
+
+			case youtubeAdRenderers[key]:
+				var ad json.RawMessage
+				if err := dec.Decode(&ad); err != nil {
+					return err
+				}
+
+			default:
+				if err := items.walk(dec, listStart); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// the closing bracket
+	_, err = dec.Token()
+	return err
+}
 
-	// make sure both arrays are equal length
-	if len(videoTitles) != len(videoURLs) {
-		return streamData, errors.New("playlist data mismatch")
+func (items *youtubeItems) addVideo(renderer youtubeVideoRenderer) {
+	// deleted and private videos are listed but can't be played
+	if renderer.VideoId == "" || (renderer.IsPlayable != nil && !*renderer.IsPlayable) {
+		items.skipped++
+		return
 	}
 
-	// format into return array
-	// streamurl, data, and imageurl must be parsed later
-	// this isn't done now for each song as it may take a very long time
-	for i, title := range videoTitles {
-		songData := StreamData{
-			Title: title,
-			URL:   fmt.Sprintf("https://www.youtube.com/watch?v=%s", videoURLs[i]),
+	// a video can be in a playlist more than once, but each of its items is
+	// only listed once
+	if renderer.SetVideoId != "" {
+		if items.setVideoIds[renderer.SetVideoId] {
+			return
 		}
-		streamData = append(streamData, songData)
+		items.setVideoIds[renderer.SetVideoId] = true
 	}
 
-	return streamData, nil
+	items.entries = append(items.entries, renderer.toStreamData())
 }
@@ -0,0 +1,128 @@
+package StreamTool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestYoutubePlaylistPage(t *testing.T) {
+	// the sidebar's continuation sorts after the playlist's and comes first
+	// in the document, neither may win over the list holding the videos
+	page := `{
+		"aSidebar": {"items": [{"continuationItemRenderer": {"continuationEndpoint": {"continuationCommand": {"token": "sidebar"}}}}]},
+		"contents": {"playlistVideoListRenderer": {"contents": [
+			{"playlistVideoRenderer": {"videoId": "aaaaaaaaaaa", "setVideoId": "1", "title": {"simpleText": "A"}}},
+			{"adSlotRenderer": {"videoId": "ad"}},
+			{"playlistVideoRenderer": {"videoId": "bbbbbbbbbbb", "setVideoId": "2", "title": {"simpleText": "B"}}},
+			{"playlistVideoRenderer": {"videoId": "", "setVideoId": "3"}},
+			{"playlistVideoRenderer": {"videoId": "aaaaaaaaaaa", "setVideoId": "4", "title": {"simpleText": "A again"}}},
+			{"continuationItemRenderer": {"continuationEndpoint": {"continuationCommand": {"token": "playlist"}}}}
+		]}},
+		"zFooter": {"continuationItemRenderer": {"continuationEndpoint": {"continuationCommand": {"token": "footer"}}}}
+	}`
+
+	setVideoIds := map[string]bool{}
+	items, err := youtubePlaylistPage(json.RawMessage(page), setVideoIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var titles []string
+	for _, entry := range items.entries {
+		titles = append(titles, entry.Title)
+	}
+	if len(titles) != 3 || titles[0] != "A" || titles[1] != "B" || titles[2] != "A again" {
+		t.Errorf("entries = %q, want [A B A again]", titles)
+	}
+	if items.skipped != 1 {
+		t.Errorf("skipped = %d, want 1", items.skipped)
+	}
+	if items.continuation != "playlist" {
+		t.Errorf("continuation = %q, want playlist", items.continuation)
+	}
+
+	// the next page repeats the last item across the boundary
+	next := `{"onResponseReceivedActions": [{"appendContinuationItemsAction": {"continuationItems": [
+		{"playlistVideoRenderer": {"videoId": "aaaaaaaaaaa", "setVideoId": "4"}},
+		{"playlistVideoRenderer": {"videoId": "ccccccccccc", "setVideoId": "5", "title": {"simpleText": "C"}}}
+	]}}]}`
+
+	items, err = youtubePlaylistPage(json.RawMessage(next), setVideoIds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items.entries) != 1 || items.entries[0].Title != "C" {
+		t.Errorf("continuation entries = %+v, want only C", items.entries)
+	}
+	if items.continuation != "" {
+		t.Errorf("continuation = %q, want none", items.continuation)
+	}
+}
+
+func TestYoutubePlaylistPageInvalid(t *testing.T) {
+	if _, err := youtubePlaylistPage(json.RawMessage(`{"contents": [`), map[string]bool{}); err == nil {
+		t.Error("truncated page didn't fail")
+	}
+}
+
+func TestYoutubePlaylistEmbed(t *testing.T) {
+	tests := []struct {
+		url       string
+		extractor string
+		listID    string
+	}{
+		{"https://www.youtube.com/embed/videoseries?list=PLabc123", "youtube:playlist", "PLabc123"},
+		{"https://www.youtube-nocookie.com/embed/videoseries?si=x&list=PLabc123&index=2", "youtube:playlist", "PLabc123"},
+		{"https://www.youtube.com/embed?listType=playlist&list=PLabc123", "youtube:playlist", "PLabc123"},
+		{"https://www.youtube.com/embed/?list=PLabc123&listType=playlist#t=1", "youtube:playlist", "PLabc123"},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ?list=PLabc123", "youtube", ""},
+		{"https://www.youtube.com/embed?listType=search&list=cats", "", ""},
+	}
+
+	for _, test := range tests {
+		extractor := findExtractor(test.url)
+		name := ""
+		if extractor != nil {
+			name = extractor.Name()
+		}
+		if name != test.extractor {
+			t.Errorf("extractor for %s = %q, want %q", test.url, name, test.extractor)
+			continue
+		}
+		if test.listID == "" {
+			continue
+		}
+
+		listID := youtubeListID(test.url, extractor.(*regexExtractor).pattern(test.url))
+		if listID != test.listID {
+			t.Errorf("list id of %s = %q, want %q", test.url, listID, test.listID)
+		}
+	}
+
+	if _, err := parseYoutubeURL("https://www.youtube.com/embed/videoseries?list=PLabc123"); !errors.Is(err, ErrUnsupportedURL) {
+		t.Errorf("parsing a playlist embed as a video error = %v, want ErrUnsupportedURL", err)
+	}
+}
+
+func TestYoutubeSearchTitle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><script>var ytInitialData = {"contents": [{"videoRenderer": {"videoId": "aaaaaaaaaaa", "title": {"simpleText": "A"}}}]};</script></body></html>`)
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURLs: map[string]string{"www.youtube.com": srv.URL}}
+	result, err := c.Resolve("https://www.youtube.com/results?search_query=lo-fi+beats%26chill&sp=EgIQAQ%253D%253D")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Kind != KindSearch || result.Playlist.Title != "lo-fi beats&chill" {
+		t.Errorf("kind %v, title %q, want a search for %q", result.Kind, result.Playlist.Title, "lo-fi beats&chill")
+	}
+	if len(result.Entries) != 1 {
+		t.Errorf("%d entries, want 1", len(result.Entries))
+	}
+}
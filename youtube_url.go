@@ -0,0 +1,84 @@
+package StreamTool
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var rxVideoID = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// timestamps such as "90", "90s", "1m30s" or "1h2m3s"
+var rxTimestamp = regexp.MustCompile(`^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s?)?$`)
+
+// what a youtube video link points to
+type youtubeURL struct {
+	videoID string
+
+	// seconds into the video playback should start at
+	start int
+
+	// playlist the video was linked from, index counts from 1
+	listID string
+	index  int
+}
+
+// pull the video id, start offset and playlist out of any form of video link
+func parseYoutubeURL(rawURL string) (youtubeURL, error) {
+	var parsed youtubeURL
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return parsed, newExtractError("youtube", StageDecode, ErrUnsupportedURL, "couldn't parse url: %w", err)
+	}
+	query := u.Query()
+
+	// youtu.be/ID, /shorts/ID, /embed/ID and /live/ID keep the id in the path
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case u.Host == "youtu.be":
+		parsed.videoID = segments[0]
+	case len(segments) >= 2 && segments[0] == "embed" && segments[1] == "videoseries":
+		// a playlist embed, whose name happens to look like an id
+		return parsed, newExtractError("youtube", StageDecode, ErrUnsupportedURL, "playlist embed isn't a video")
+	case len(segments) >= 2 && (segments[0] == "shorts" || segments[0] == "embed" || segments[0] == "live"):
+		parsed.videoID = segments[1]
+	default:
+		parsed.videoID = query.Get("v")
+	}
+
+	if !rxVideoID.MatchString(parsed.videoID) {
+		return parsed, newExtractError("youtube", StageDecode, ErrUnsupportedURL, "invalid video id %q", parsed.videoID)
+	}
+
+	// shared links use t, embeds use start, and some put t in the fragment
+	timestamp := query.Get("t")
+	if timestamp == "" {
+		timestamp = query.Get("start")
+	}
+	if timestamp == "" && strings.HasPrefix(u.Fragment, "t=") {
+		timestamp = strings.TrimPrefix(u.Fragment, "t=")
+	}
+	parsed.start = parseTimestamp(timestamp)
+
+	parsed.listID = query.Get("list")
+	parsed.index, _ = strconv.Atoi(query.Get("index"))
+
+	return parsed, nil
+}
+
+// seconds in a timestamp, 0 when it can't be read
+func parseTimestamp(str string) int {
+	match := rxTimestamp.FindStringSubmatch(str)
+	if match == nil {
+		return 0
+	}
+
+	seconds := 0
+	for i, multiplier := range []int{3600, 60, 1} {
+		num, _ := strconv.Atoi(match[i+1])
+		seconds += num * multiplier
+	}
+	return seconds
+}
@@ -0,0 +1,73 @@
+package StreamTool
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseYoutubeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want youtubeURL
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ", youtubeURL{videoID: "dQw4w9WgXcQ"}},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PLabc123&t=42s", youtubeURL{videoID: "dQw4w9WgXcQ", start: 42, listID: "PLabc123"}},
+		{"https://music.youtube.com/watch?feature=share&v=dQw4w9WgXcQ&list=PLabc123&index=7", youtubeURL{videoID: "dQw4w9WgXcQ", listID: "PLabc123", index: 7}},
+		{"https://m.youtube.com/watch?v=dQw4w9WgXcQ#t=1m30s", youtubeURL{videoID: "dQw4w9WgXcQ", start: 90}},
+		{"https://youtu.be/dQw4w9WgXcQ", youtubeURL{videoID: "dQw4w9WgXcQ"}},
+		{"https://youtu.be/dQw4w9WgXcQ?si=AbCdEf&t=90", youtubeURL{videoID: "dQw4w9WgXcQ", start: 90}},
+		{"https://www.youtube.com/shorts/dQw4w9WgXcQ?feature=share", youtubeURL{videoID: "dQw4w9WgXcQ"}},
+		{"https://www.youtube.com/embed/dQw4w9WgXcQ?start=15&list=PLabc123", youtubeURL{videoID: "dQw4w9WgXcQ", start: 15, listID: "PLabc123"}},
+		{"https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ", youtubeURL{videoID: "dQw4w9WgXcQ"}},
+		{"https://www.youtube.com/live/dQw4w9WgXcQ?t=1h2m3s", youtubeURL{videoID: "dQw4w9WgXcQ", start: 3723}},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=2m", youtubeURL{videoID: "dQw4w9WgXcQ", start: 120}},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=soon&index=x", youtubeURL{videoID: "dQw4w9WgXcQ"}},
+	}
+
+	for _, test := range tests {
+		got, err := parseYoutubeURL(test.url)
+		if err != nil {
+			t.Errorf("parseYoutubeURL(%s): %v", test.url, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseYoutubeURL(%s) = %+v, want %+v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestParseYoutubeURLInvalid(t *testing.T) {
+	for _, url := range []string{
+		"https://www.youtube.com/watch?v=short",
+		"https://www.youtube.com/watch?list=PLabc123",
+		"https://youtu.be/",
+		"https://www.youtube.com/shorts/dQw4w9WgXcQextra",
+		"https://www.youtube.com/embed/videoseries?list=PLabc123",
+	} {
+		if _, err := parseYoutubeURL(url); !errors.Is(err, ErrUnsupportedURL) {
+			t.Errorf("parseYoutubeURL(%s) error = %v, want ErrUnsupportedURL", url, err)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := map[string]int{
+		"":       0,
+		"42":     42,
+		"42s":    42,
+		"2m":     120,
+		"1m30s":  90,
+		"1h":     3600,
+		"1h2m3s": 3723,
+		"1h5s":   3605,
+		"soon":   0,
+		"-5":     0,
+		"1m30x":  0,
+	}
+
+	for str, want := range tests {
+		if got := parseTimestamp(str); got != want {
+			t.Errorf("parseTimestamp(%q) = %d, want %d", str, got, want)
+		}
+	}
+}